@@ -2,85 +2,162 @@ package wordepress
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
 )
 
-func PostDocument(user, password, endpoint string, document *Document) (*Document, error) {
-	requestBytes, err := json.Marshal(document)
+const DefaultTimeout = 60 * time.Second
+
+// maxConcurrentPages bounds the number of pages of a listing fetched at once
+const maxConcurrentPages = 4
+
+const DefaultRestBase = "documentation"
+
+type Client struct {
+	BaseURL string
+	Auth    Authenticator
+
+	// RestBase is the REST base of the post type documents are published as
+	// (e.g. "pages"), and Fields the names of the fields holding their
+	// product, version, name and tag
+	RestBase string
+	Fields   FieldNames
+
+	// HTTPClient is used for all requests; replace it to reuse an existing
+	// transport or to substitute a fake one under test
+	HTTPClient *http.Client
+
+	Retry RetryPolicy
+
+	// Limiter, if set, bounds the rate of requests (including retries) made
+	// by this client
+	Limiter *rate.Limiter
+
+	// media caches the attachment (or nil if there is none) uploaded for each
+	// image hash looked up by this client
+	mediaMu sync.Mutex
+	media   map[string]*Media
+}
+
+func NewClient(baseURL string, auth Authenticator) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Auth:       auth,
+		RestBase:   DefaultRestBase,
+		Fields:     DefaultFieldNames,
+		HTTPClient: &http.Client{Timeout: DefaultTimeout},
+		Retry:      DefaultRetryPolicy}
+}
+
+func (c *Client) DocumentsEndpoint() string {
+	return c.BaseURL + "/wp-json/wp/v2/" + c.RestBase
+}
+
+func (c *Client) MediaEndpoint() string {
+	return c.BaseURL + "/wp-json/wp/v2/media"
+}
+
+func (c *Client) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequest(method, url, body)
 	if err != nil {
 		return nil, err
 	}
-
-	request, err := http.NewRequest("POST", endpoint, bytes.NewReader(requestBytes))
-	request.SetBasicAuth(user, password)
-	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/json")
+	return request.WithContext(ctx), nil
+}
+
+func (c *Client) PostDocument(ctx context.Context, document *Document) (*Document, error) {
+	requestBytes, err := c.marshalDocument(document)
+	if err != nil {
+		return nil, err
+	}
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	request, err := c.newRequest(ctx, "POST", c.DocumentsEndpoint(), bytes.NewReader(requestBytes))
 	if err != nil {
 		return nil, err
 	}
+	request.Header.Set("Content-Type", "application/json")
+
+	// Retrying a POST whose response was lost would create a duplicate with a
+	// de-duplicated slug, so check whether the first attempt landed instead
+	var applied *Document
+	probe := func(ctx context.Context) (bool, error) {
+		var err error
+		applied, err = c.getDocumentBySlug(ctx, document.Slug)
+		return applied != nil, err
+	}
 
-	responseBytes, err := ioutil.ReadAll(response.Body)
-	response.Body.Close()
+	response, responseBytes, err := c.do(request, probe)
+	if err == errApplied {
+		return applied, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	if response.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("post failed: %v %s", response.Status, string(responseBytes))
+		return nil, newAPIError(response, responseBytes)
 	}
 
 	var remoteDocument Document
-	err = json.Unmarshal(responseBytes, &remoteDocument)
+	err = c.unmarshalDocument(responseBytes, &remoteDocument)
 	if err != nil {
 		return nil, err
 	}
 
-	// Ensure server honoured our slug
-	if remoteDocument.Slug != document.Slug {
-		return nil, fmt.Errorf("duplicate slug: requested %s, response %s",
-			document.Slug, remoteDocument.Slug)
+	err = checkSlug(document, &remoteDocument)
+	if err != nil {
+		return nil, err
 	}
 
 	return &remoteDocument, nil
 }
 
-func PutDocument(user, password, endpoint string, ID int, document *Document) (*Document, error) {
-	requestBytes, err := json.Marshal(document)
+// checkSlug ensures the server honoured our slug, rather than de-duplicating it
+// because it is already taken
+func checkSlug(document, remoteDocument *Document) error {
+	if remoteDocument.Slug != document.Slug {
+		return fmt.Errorf("duplicate slug: requested %s, response %s",
+			document.Slug, remoteDocument.Slug)
+	}
+	return nil
+}
+
+func (c *Client) PutDocument(ctx context.Context, ID int, document *Document) (*Document, error) {
+	requestBytes, err := c.marshalDocument(document)
 	if err != nil {
 		return nil, err
 	}
 
-	url := fmt.Sprintf("%s/%d", endpoint, ID)
-	request, err := http.NewRequest("PUT", url, bytes.NewReader(requestBytes))
-	request.SetBasicAuth(user, password)
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("Accept", "application/json")
-
-	client := &http.Client{}
-	response, err := client.Do(request)
+	url := fmt.Sprintf("%s/%d", c.DocumentsEndpoint(), ID)
+	request, err := c.newRequest(ctx, "PUT", url, bytes.NewReader(requestBytes))
 	if err != nil {
 		return nil, err
 	}
+	request.Header.Set("Content-Type", "application/json")
 
-	responseBytes, err := ioutil.ReadAll(response.Body)
-	response.Body.Close()
+	response, responseBytes, err := c.do(request, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("post failed: %v %s", response.Status, string(responseBytes))
+		return nil, newAPIError(response, responseBytes)
 	}
 
 	var remoteDocument Document
-	err = json.Unmarshal(responseBytes, &remoteDocument)
+	err = c.unmarshalDocument(responseBytes, &remoteDocument)
 	if err != nil {
 		return nil, err
 	}
@@ -88,68 +165,183 @@ func PutDocument(user, password, endpoint string, ID int, document *Document) (*
 	return &remoteDocument, nil
 }
 
-func GetDocuments(user, password, endpoint, query string) ([]*Document, error) {
+// GetDocuments lists every document matching query. Unless the query
+// specifies its own _fields projection, only the fields of Document are
+// requested, with rendered text omitted (nested projections require WordPress
+// 5.3 or later; earlier versions ignore them).
+func (c *Client) GetDocuments(ctx context.Context, query string) ([]*Document, error) {
+	return c.GetDocumentsOf(ctx, c.RestBase, query)
+}
+
+// GetDocumentsOf lists every document matching query of the post type with
+// the given REST base, which may not be the client's own
+func (c *Client) GetDocumentsOf(ctx context.Context, restBase, query string) ([]*Document, error) {
+	if !strings.Contains(query, "_fields=") {
+		query += "&_fields=" + strings.Join(c.DocumentFields(), ",")
+	}
+
+	pages, err := c.getPages(ctx, c.BaseURL+"/wp-json/wp/v2/"+restBase, query)
+	if err != nil {
+		return nil, err
+	}
+
 	var jsonDocuments []*Document
-	for page := 1; true; page++ {
-		url := fmt.Sprintf("%s?%s&page=%d", endpoint, query, page)
-		request, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return nil, err
+	for _, page := range pages {
+		for _, object := range page {
+			document := &Document{}
+			err = c.unmarshalDocument(object, document)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("Loaded document %s", document.Slug)
+			jsonDocuments = append(jsonDocuments, document)
 		}
-		request.SetBasicAuth(user, password)
-		request.Header.Set("Accept", "application/json")
+	}
+	return jsonDocuments, nil
+}
 
-		client := &http.Client{}
-		response, err := client.Do(request)
-		if err != nil {
-			return nil, err
-		}
+// getPages fetches every page of the collection at endpoint matching query.
+// The first page determines the total number of pages, after which the
+// remainder are fetched concurrently.
+func (c *Client) getPages(ctx context.Context, endpoint, query string) ([][]json.RawMessage, error) {
+	first, totalPages, err := c.getPage(ctx, endpoint, query, 1)
+	if err != nil {
+		return nil, err
+	}
 
-		responseBytes, err := ioutil.ReadAll(response.Body)
-		response.Body.Close()
-		if err != nil {
-			return nil, err
+	pages := [][]json.RawMessage{first}
+	if totalPages < 0 {
+		// X-WP-TotalPages was stripped (e.g. by a proxy), so fall back to
+		// paging until we run out
+		for page := 2; len(pages[len(pages)-1]) > 0; page++ {
+			objects, _, err := c.getPage(ctx, endpoint, query, page)
+			if ErrorCode(err) == "rest_post_invalid_page_number" {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			pages = append(pages, objects)
 		}
+	} else if totalPages > 1 {
+		pages = append(pages, make([][]json.RawMessage, totalPages-1)...)
 
-		if response.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("%v", response.Status)
-		}
+		// The first failure abandons the other pages, rather than waiting
+		// for them to exhaust their retries
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		var (
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			firstErr  error
+			semaphore = make(chan struct{}, maxConcurrentPages)
+		)
+		for page := 2; page <= totalPages; page++ {
+			wg.Add(1)
+			go func(page int) {
+				defer wg.Done()
+				select {
+				case semaphore <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				defer func() { <-semaphore }()
 
-		var jsonPage []Document
-		err = json.Unmarshal(responseBytes, &jsonPage)
-		if err != nil {
-			return nil, err
+				objects, _, err := c.getPage(ctx, endpoint, query, page)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				pages[page-1] = objects
+			}(page)
 		}
+		wg.Wait()
 
-		if len(jsonPage) == 0 {
-			break
+		if firstErr != nil {
+			return nil, firstErr
 		}
+	}
 
-		for i := 0; i < len(jsonPage); i++ {
-			log.Printf("Loaded document %s", jsonPage[i].Slug)
-			jsonDocuments = append(jsonDocuments, &jsonPage[i])
-		}
+	return pages, nil
+}
+
+// getPage fetches a single page of a collection, also returning the total
+// number of pages or -1 if the server didn't say
+func (c *Client) getPage(ctx context.Context, endpoint, query string, page int) ([]json.RawMessage, int, error) {
+	url := fmt.Sprintf("%s?%s&page=%d", endpoint, query, page)
+	request, err := c.newRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, 0, err
 	}
-	return jsonDocuments, nil
+
+	response, responseBytes, err := c.do(request, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, 0, newAPIError(response, responseBytes)
+	}
+
+	var objects []json.RawMessage
+	err = json.Unmarshal(responseBytes, &objects)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	totalPages, err := strconv.Atoi(response.Header.Get("X-WP-TotalPages"))
+	if err != nil {
+		totalPages = -1
+	}
+
+	return objects, totalPages, nil
 }
 
-func DeleteDocument(user, password, endpoint string, jsonDocument *Document) error {
-	url := fmt.Sprintf("%s/%d?force=true", endpoint, jsonDocument.ID)
-	request, err := http.NewRequest("DELETE", url, nil)
+func (c *Client) DeleteDocument(ctx context.Context, jsonDocument *Document) error {
+	url := fmt.Sprintf("%s/%d?force=true", c.DocumentsEndpoint(), jsonDocument.ID)
+	request, err := c.newRequest(ctx, "DELETE", url, nil)
 	if err != nil {
 		return err
 	}
-	request.SetBasicAuth(user, password)
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, responseBytes, err := c.do(request, nil)
 	if err != nil {
 		return err
 	}
-	response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("%v", response.Status)
+		return newAPIError(response, responseBytes)
 	}
 	return nil
 }
+
+func (c *Client) getDocumentBySlug(ctx context.Context, slug string) (*Document, error) {
+	endpoint := fmt.Sprintf("%s?context=edit&status=any&slug=%s", c.DocumentsEndpoint(), url.QueryEscape(slug))
+	request, err := c.newRequest(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, responseBytes, err := c.do(request, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, newAPIError(response, responseBytes)
+	}
+
+	jsonPage, err := c.unmarshalDocuments(responseBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, document := range jsonPage {
+		if document.Slug == slug {
+			return document, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,235 @@
+package wordepress
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/weaveworks/wordepress/wordepresstest"
+)
+
+func newTestClient(t *testing.T) (*Client, *wordepresstest.Server) {
+	server := wordepresstest.NewServer()
+	t.Cleanup(server.Close)
+	client := NewClient(server.URL, nil)
+	client.Retry.MaxAttempts = 1
+	return client, server
+}
+
+func newTestDocument(name string) *Document {
+	return &Document{
+		Title:   Text{Raw: name},
+		Content: Text{Raw: "<p>" + name + "</p>"},
+		Product: "scope",
+		Version: "1.0",
+		Name:    name,
+		Tag:     "v1.0",
+		Slug:    "scope-v1.0-" + name,
+		Status:  "publish"}
+}
+
+// stripTotalPages removes X-WP-TotalPages from responses, as some proxies do
+type stripTotalPages struct{}
+
+func (stripTotalPages) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := http.DefaultTransport.RoundTrip(request)
+	if err == nil {
+		response.Header.Del("X-WP-TotalPages")
+	}
+	return response, err
+}
+
+func TestGetDocumentsPaging(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		stripped bool
+		empty    bool
+	}{
+		{"total pages", false, false},
+		{"no total pages", true, false},
+		{"no total pages, empty past last page", true, true},
+	} {
+		client, server := newTestClient(t)
+		server.EmptyPastLastPage = test.empty
+		if test.stripped {
+			client.HTTPClient = &http.Client{Transport: stripTotalPages{}}
+		}
+		ctx := context.Background()
+
+		for i := 1; i <= 7; i++ {
+			if _, err := client.PostDocument(ctx, newTestDocument(fmt.Sprintf("page%d", i))); err != nil {
+				t.Fatalf("PostDocument: %v", err)
+			}
+		}
+		// Another product's documents are filtered out
+		other := newTestDocument("other")
+		other.Product, other.Slug = "flux", "flux-v1.0-other"
+		if _, err := client.PostDocument(ctx, other); err != nil {
+			t.Fatalf("PostDocument: %v", err)
+		}
+
+		documents, err := client.GetDocuments(ctx, "context=edit&per_page=2&status=any&"+client.ProductQuery("scope", "v1.0"))
+		if err != nil {
+			t.Fatalf("%s: GetDocuments: %v", test.name, err)
+		}
+		if len(documents) != 7 {
+			t.Fatalf("%s: got %d documents, want 7", test.name, len(documents))
+		}
+		for i, document := range documents {
+			if want := fmt.Sprintf("page%d", i+1); document.Name != want || document.Content.Raw != "<p>"+want+"</p>" {
+				t.Errorf("%s: document %d is %s with content %q", test.name, i, document.Name, document.Content.Raw)
+			}
+		}
+	}
+}
+
+func TestGetDocumentsPageError(t *testing.T) {
+	client, _ := newTestClient(t)
+	ctx := context.Background()
+	for i := 1; i <= 6; i++ {
+		if _, err := client.PostDocument(ctx, newTestDocument(fmt.Sprintf("page%d", i))); err != nil {
+			t.Fatalf("PostDocument: %v", err)
+		}
+	}
+
+	// The second page fails while the rest never answer, so are only
+	// abandoned once the fetch is cancelled
+	client.HTTPClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		switch r.URL.Query().Get("page") {
+		case "1":
+			return http.DefaultTransport.RoundTrip(r)
+		case "2":
+			return &http.Response{
+				StatusCode: http.StatusInternalServerError,
+				Status:     "500 Internal Server Error",
+				Header:     make(http.Header),
+				Body:       ioutil.NopCloser(strings.NewReader(`{"code":"broken","message":"Broken"}`)),
+				Request:    r}, nil
+		}
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	})}
+
+	_, err := client.GetDocuments(ctx, "context=edit&per_page=1&status=any")
+	if ErrorCode(err) != "broken" {
+		t.Fatalf("got %v, want the second page's error", err)
+	}
+}
+
+func TestPostDocumentDuplicateSlug(t *testing.T) {
+	client, server := newTestClient(t)
+	server.AddPost(DefaultRestBase, wordepresstest.Record{"slug": "scope-v1.0-install", "status": "draft"})
+
+	_, err := client.PostDocument(context.Background(), newTestDocument("install"))
+	if err == nil || !strings.Contains(err.Error(), "duplicate slug") {
+		t.Fatalf("got %v, want a duplicate slug error", err)
+	}
+}
+
+func TestGetDocumentBySlug(t *testing.T) {
+	client, server := newTestClient(t)
+	// Characters that mean something in a query string
+	server.AddPost(DefaultRestBase, wordepresstest.Record{"slug": "c++&go=1", "status": "publish"})
+
+	document, err := client.getDocumentBySlug(context.Background(), "c++&go=1")
+	if err != nil {
+		t.Fatalf("getDocumentBySlug: %v", err)
+	}
+	if document == nil || document.Slug != "c++&go=1" {
+		t.Fatalf("found %v", document)
+	}
+}
+
+func TestPutDocument(t *testing.T) {
+	client, _ := newTestClient(t)
+	ctx := context.Background()
+
+	posted, err := client.PostDocument(ctx, newTestDocument("install"))
+	if err != nil {
+		t.Fatalf("PostDocument: %v", err)
+	}
+	document := newTestDocument("install")
+	document.Content.Raw = "<p>Updated</p>"
+	put, err := client.PutDocument(ctx, posted.ID, document)
+	if err != nil {
+		t.Fatalf("PutDocument: %v", err)
+	}
+	if put.ID != posted.ID || put.Content.Raw != "<p>Updated</p>" || put.Product != "scope" {
+		t.Errorf("PutDocument returned %+v", put)
+	}
+
+	if _, err := client.PutDocument(ctx, posted.ID+100, document); ErrorCode(err) != "rest_post_invalid_id" {
+		t.Errorf("PutDocument of a missing document: %v", err)
+	}
+}
+
+func TestDeleteDocument(t *testing.T) {
+	client, server := newTestClient(t)
+	ctx := context.Background()
+
+	posted, err := client.PostDocument(ctx, newTestDocument("install"))
+	if err != nil {
+		t.Fatalf("PostDocument: %v", err)
+	}
+	if err := client.DeleteDocument(ctx, posted); err != nil {
+		t.Fatalf("DeleteDocument: %v", err)
+	}
+	// Deleted outright rather than trashed, which would keep its slug taken
+	if posts := server.Posts(DefaultRestBase); len(posts) != 0 {
+		t.Errorf("%d documents remain after delete", len(posts))
+	}
+}
+
+func TestBatch(t *testing.T) {
+	client, server := newTestClient(t)
+	ctx := context.Background()
+
+	size, err := client.BatchSize(ctx)
+	if err != nil || size != wordepresstest.MaxBatchSize {
+		t.Fatalf("BatchSize %d, %v", size, err)
+	}
+
+	existing, err := client.PostDocument(ctx, newTestDocument("existing"))
+	if err != nil {
+		t.Fatalf("PostDocument: %v", err)
+	}
+	doomed, err := client.PostDocument(ctx, newTestDocument("doomed"))
+	if err != nil {
+		t.Fatalf("PostDocument: %v", err)
+	}
+
+	updated := newTestDocument("existing")
+	updated.Content.Raw = "<p>Updated</p>"
+	operations := []*BatchOperation{
+		{Method: "POST", Document: newTestDocument("created")},
+		{Method: "PUT", ID: existing.ID, Document: updated},
+		{Method: "DELETE", ID: doomed.ID},
+		{Method: "PUT", ID: doomed.ID + 100, Document: updated}}
+	if err := client.Batch(ctx, operations); err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+
+	if result := operations[0].Result; operations[0].Err != nil || result == nil || result.Slug != "scope-v1.0-created" {
+		t.Errorf("create: %+v, %v", result, operations[0].Err)
+	}
+	if result := operations[1].Result; operations[1].Err != nil || result == nil || result.Content.Raw != "<p>Updated</p>" {
+		t.Errorf("update: %+v, %v", result, operations[1].Err)
+	}
+	if operations[2].Err != nil {
+		t.Errorf("delete: %v", operations[2].Err)
+	}
+	if ErrorCode(operations[3].Err) != "rest_post_invalid_id" {
+		t.Errorf("update of a missing document: %v", operations[3].Err)
+	}
+	if posts := server.Posts(DefaultRestBase); len(posts) != 2 {
+		t.Errorf("%d documents after batch, want 2", len(posts))
+	}
+
+	server.DisableBatch = true
+	if size, err := client.BatchSize(ctx); err != nil || size != 0 {
+		t.Errorf("BatchSize without the batch API %d, %v", size, err)
+	}
+}
@@ -0,0 +1,98 @@
+package wordepress
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// AssetOptions configures the uploading of local files linked to from
+// documents, such as PDFs and example manifests
+type AssetOptions struct {
+	// Extensions lists the extensions (without the dot) of linked files to
+	// upload; links to other files are left as they are
+	Extensions []string
+
+	// MaxSize is the size in bytes above which a linked file is refused
+	// (0 for no limit)
+	MaxSize int64
+}
+
+func (o AssetOptions) allowed(filename string) bool {
+	extension := strings.TrimPrefix(strings.ToLower(path.Ext(filename)), ".")
+	for _, allowed := range o.Extensions {
+		if strings.TrimPrefix(strings.ToLower(allowed), ".") == extension {
+			return extension != ""
+		}
+	}
+	return false
+}
+
+// localAsset returns the file in srcdir referred to by href, or "" if href
+// isn't a relative link to a file other than markdown
+func localAsset(srcdir, href string) string {
+	parsed, err := url.Parse(href)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" || parsed.RawQuery != "" ||
+		parsed.Path == "" || strings.HasPrefix(parsed.Path, "/") || strings.HasSuffix(parsed.Path, ".md") {
+		return ""
+	}
+	return path.Join(srcdir, parsed.Path)
+}
+
+// insideSite reports whether a file is within the root directory of a site,
+// and so may be uploaded to the media library
+func insideSite(root, filename string) bool {
+	rel, err := filepath.Rel(root, filename)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	return rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// ReadAsset describes a linked file for upload alongside images, without
+// reading it into memory. Its MIME type is taken from its extension where
+// known, as sniffing can't tell YAML from plain text.
+func ReadAsset(filename string, options AssetOptions) (*Image, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if options.MaxSize > 0 && info.Size() > options.MaxSize {
+		return nil, fmt.Errorf("%s is %d bytes, more than the limit of %d", filename, info.Size(), options.MaxSize)
+	}
+
+	head, err := readHead(file)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, size, err := hashFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	extension := path.Ext(filename)
+	mimeType := mime.TypeByExtension(extension)
+	if mimeType == "" {
+		mimeType = http.DetectContentType(head)
+	}
+
+	return &Image{
+		Filename:  filename,
+		Extension: extension,
+		MimeType:  mimeType,
+		Hash:      hash,
+		Size:      size}, nil
+}
@@ -0,0 +1,173 @@
+package wordepress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTokenServer returns a JWT plugin stand-in issuing the given tokens in
+// turn, formatted into the JSON response given
+func newTokenServer(t *testing.T, response string, tokens ...string) (*httptest.Server, *int) {
+	var mu sync.Mutex
+	issued := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var credentials struct{ Username, Password string }
+		json.NewDecoder(r.Body).Decode(&credentials)
+		if credentials.Username != "admin" || credentials.Password != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"code":"[jwt_auth] incorrect_password","message":"The password you entered is incorrect.","data":{"status":403}}`)
+			return
+		}
+		mu.Lock()
+		token := tokens[issued%len(tokens)]
+		issued++
+		mu.Unlock()
+		fmt.Fprintf(w, response, token)
+	}))
+	t.Cleanup(server.Close)
+	return server, &issued
+}
+
+func TestBearerAuthFetchToken(t *testing.T) {
+	// The popular JWT plugins disagree on where the token lives
+	for _, response := range []string{
+		`{"token":%q}`,
+		`{"jwt":%q}`,
+		`{"data":{"token":%q}}`,
+		`{"data":{"jwt":%q}}`,
+	} {
+		tokenServer, _ := newTokenServer(t, response, "abc")
+		auth := &BearerAuth{TokenURL: tokenServer.URL, User: "admin", Password: "secret"}
+		request := httptest.NewRequest("GET", "/", nil)
+		if err := auth.Authenticate(context.Background(), request); err != nil {
+			t.Fatalf("Authenticate: %v", err)
+		}
+		if got := request.Header.Get("Authorization"); got != "Bearer abc" {
+			t.Errorf("Authorization %q, want the fetched token", got)
+		}
+	}
+
+	tokenServer, _ := newTokenServer(t, `{"token":%q}`, "abc")
+	auth := &BearerAuth{TokenURL: tokenServer.URL, User: "admin", Password: "wrong"}
+	err := auth.Authenticate(context.Background(), httptest.NewRequest("GET", "/", nil))
+	if ErrorCode(err) != "[jwt_auth] incorrect_password" {
+		t.Errorf("got %v, want the plugin's error", err)
+	}
+}
+
+func TestBearerAuthReauthenticate(t *testing.T) {
+	client, server := newTestClient(t)
+	server.Token = "fresh"
+
+	// The first token has expired by the time it's used
+	tokenServer, issued := newTokenServer(t, `{"token":%q}`, "expired", "fresh")
+	client.Auth = &BearerAuth{TokenURL: tokenServer.URL, User: "admin", Password: "secret"}
+
+	if _, err := client.PostDocument(context.Background(), newTestDocument("install")); err != nil {
+		t.Fatalf("PostDocument: %v", err)
+	}
+	if *issued != 2 {
+		t.Errorf("%d tokens issued, want a second after the first was rejected", *issued)
+	}
+
+	// A static token can't be refreshed
+	client.Auth = &BearerAuth{Token: "expired"}
+	_, err := client.GetDocuments(context.Background(), "per_page=100")
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got %v, want the 401", err)
+	}
+}
+
+// cookieSite stands in for the login and nonce endpoints of WordPress,
+// accepting REST requests carrying the session cookie and current nonce
+type cookieSite struct {
+	*httptest.Server
+	mu     sync.Mutex
+	logins int
+	nonce  string
+}
+
+func newCookieSite(t *testing.T) *cookieSite {
+	site := &cookieSite{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/wp-login.php", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("wordpress_test_cookie"); err != nil {
+			fmt.Fprint(w, "Cookies are blocked or not supported by your browser.")
+			return
+		}
+		if r.PostFormValue("log") != "admin" || r.PostFormValue("pwd") != "secret" {
+			fmt.Fprint(w, "The password you entered is incorrect.")
+			return
+		}
+		site.mu.Lock()
+		site.logins++
+		site.nonce = fmt.Sprintf("nonce%d", site.logins)
+		site.mu.Unlock()
+		http.SetCookie(w, &http.Cookie{Name: "wordpress_logged_in_0123", Value: "admin", Path: "/"})
+		http.Redirect(w, r, "/wp-admin/", http.StatusFound)
+	})
+	mux.HandleFunc("/wp-admin/admin-ajax.php", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("wordpress_logged_in_0123"); err != nil || r.URL.Query().Get("action") != "rest-nonce" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "0")
+			return
+		}
+		site.mu.Lock()
+		defer site.mu.Unlock()
+		fmt.Fprint(w, site.nonce)
+	})
+	mux.HandleFunc("/wp-json/wp/v2/documentation", func(w http.ResponseWriter, r *http.Request) {
+		site.mu.Lock()
+		nonce := site.nonce
+		site.mu.Unlock()
+		if _, err := r.Cookie("wordpress_logged_in_0123"); err != nil || r.Header.Get("X-WP-Nonce") != nonce {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"code":"rest_not_logged_in","message":"You are not currently logged in.","data":{"status":401}}`)
+			return
+		}
+		w.Header().Set("X-WP-TotalPages", "1")
+		fmt.Fprint(w, "[]")
+	})
+	site.Server = httptest.NewServer(mux)
+	t.Cleanup(site.Close)
+	return site
+}
+
+func TestCookieAuth(t *testing.T) {
+	site := newCookieSite(t)
+	client := NewClient(site.URL, nil)
+	client.Retry.MaxAttempts = 1
+	client.Auth = &CookieAuth{BaseURL: site.URL, User: "admin", Password: "secret", HTTPClient: client.HTTPClient}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetDocuments(context.Background(), "per_page=100"); err != nil {
+			t.Fatalf("GetDocuments: %v", err)
+		}
+	}
+	if site.logins != 1 {
+		t.Errorf("%d logins, want the session reused", site.logins)
+	}
+
+	// An expired nonce is replaced by logging in again
+	site.mu.Lock()
+	site.nonce = "expired"
+	site.mu.Unlock()
+	if _, err := client.GetDocuments(context.Background(), "per_page=100"); err != nil {
+		t.Fatalf("GetDocuments after expiry: %v", err)
+	}
+	if site.logins != 2 {
+		t.Errorf("%d logins, want a second after the nonce expired", site.logins)
+	}
+
+	client.Auth = &CookieAuth{BaseURL: site.URL, User: "admin", Password: "wrong", HTTPClient: client.HTTPClient}
+	_, err := client.GetDocuments(context.Background(), "per_page=100")
+	if err == nil || !strings.Contains(err.Error(), "cookie login failed") {
+		t.Errorf("got %v, want the login refused", err)
+	}
+}
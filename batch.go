@@ -0,0 +1,185 @@
+package wordepress
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// BatchOperation is a single document create, update or delete submitted as
+// part of a batch. Result and Err are filled in once the batch has executed.
+type BatchOperation struct {
+	// Method is POST to create Document, PUT to update the remote document
+	// with ID or DELETE to remove it
+	Method   string
+	ID       int
+	Document *Document
+
+	Result *Document
+	Err    error
+}
+
+type batchRequest struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+type batchResponse struct {
+	Failed    string `json:"failed"`
+	Responses []struct {
+		Status int             `json:"status"`
+		Body   json.RawMessage `json:"body"`
+	} `json:"responses"`
+}
+
+func (c *Client) BatchEndpoint() string {
+	return c.BaseURL + "/wp-json/batch/v1"
+}
+
+// BatchSize returns the maximum number of operations WordPress accepts in a
+// single batch request, or zero if the batch API (WordPress 5.6+) is
+// unavailable
+func (c *Client) BatchSize(ctx context.Context) (int, error) {
+	request, err := c.newRequest(ctx, "OPTIONS", c.BatchEndpoint(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	response, responseBytes, err := c.do(request, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		return 0, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return 0, newAPIError(response, responseBytes)
+	}
+
+	var schema struct {
+		Endpoints []struct {
+			Args struct {
+				Requests struct {
+					MaxItems int `json:"maxItems"`
+				} `json:"requests"`
+			} `json:"args"`
+		} `json:"endpoints"`
+	}
+	err = json.Unmarshal(responseBytes, &schema)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, endpoint := range schema.Endpoints {
+		if endpoint.Args.Requests.MaxItems > 0 {
+			return endpoint.Args.Requests.MaxItems, nil
+		}
+	}
+	return 0, nil
+}
+
+// Batch submits operations to the batch API in a single request, which must
+// not exceed BatchSize. The returned error reports failure of the batch as a
+// whole; the outcome of each operation is recorded in its Result and Err.
+func (c *Client) Batch(ctx context.Context, operations []*BatchOperation) error {
+	path := "/wp/v2/" + c.RestBase
+	var requests []batchRequest
+	for _, operation := range operations {
+		request := batchRequest{Method: operation.Method}
+		switch operation.Method {
+		case "POST":
+			request.Path = path
+		case "PUT":
+			request.Path = fmt.Sprintf("%s/%d", path, operation.ID)
+		case "DELETE":
+			request.Path = fmt.Sprintf("%s/%d?force=true", path, operation.ID)
+		default:
+			return fmt.Errorf("unsupported batch method: %s", operation.Method)
+		}
+		if operation.Method != "DELETE" {
+			body, err := c.marshalDocument(operation.Document)
+			if err != nil {
+				return err
+			}
+			request.Body = body
+		}
+		requests = append(requests, request)
+	}
+
+	requestBytes, err := json.Marshal(map[string]interface{}{
+		"validation": "normal",
+		"requests":   requests})
+	if err != nil {
+		return err
+	}
+
+	request, err := c.newRequest(ctx, "POST", c.BatchEndpoint(), bytes.NewReader(requestBytes))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	// A batch may contain creates, so only retry it if WordPress rejected it
+	// outright
+	response, responseBytes, err := c.do(request, nil)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != http.StatusMultiStatus && response.StatusCode != http.StatusOK {
+		return newAPIError(response, responseBytes)
+	}
+
+	var batch batchResponse
+	err = json.Unmarshal(responseBytes, &batch)
+	if err != nil {
+		return err
+	}
+
+	if len(batch.Responses) != len(operations) {
+		return fmt.Errorf("batch returned %d responses for %d requests",
+			len(batch.Responses), len(operations))
+	}
+
+	for i, operation := range operations {
+		status, body := batch.Responses[i].Status, batch.Responses[i].Body
+		if status < 200 || status > 299 {
+			operation.Err = apiErrorFor(status, fmt.Sprintf("%d %s", status, http.StatusText(status)), body)
+			continue
+		}
+
+		if operation.Method == "DELETE" {
+			continue
+		}
+
+		var remoteDocument Document
+		operation.Err = c.unmarshalDocument(body, &remoteDocument)
+		if operation.Err != nil {
+			continue
+		}
+		if operation.Method == "POST" {
+			operation.Err = checkSlug(operation.Document, &remoteDocument)
+			if operation.Err != nil {
+				continue
+			}
+		}
+		operation.Result = &remoteDocument
+	}
+
+	if batch.Failed != "" {
+		var failed []string
+		for _, operation := range operations {
+			if operation.Err != nil {
+				failed = append(failed, operation.Err.Error())
+			}
+		}
+		return fmt.Errorf("batch failed %s: %s", batch.Failed, strings.Join(failed, "; "))
+	}
+
+	return nil
+}
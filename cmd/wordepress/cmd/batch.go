@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/weaveworks/wordepress"
+)
+
+// batchSize returns the number of document operations to combine into each
+// batch request, or zero if they should be made individually
+func batchSize(ctx context.Context, client *wordepress.Client) int {
+	if !batch || dryRun {
+		return 0
+	}
+	size, err := client.BatchSize(ctx)
+	if err != nil {
+		log.Printf("Unable to determine batch API support, disabling batching: %v", err)
+		return 0
+	}
+	if size == 0 {
+		log.Printf("Batch API unavailable, publishing documents individually")
+	}
+	return size
+}
+
+// runBatches submits operations in batches of at most size, with up to
+// concurrency batches in flight at once
+func runBatches(ctx context.Context, client *wordepress.Client, size int, operations []*wordepress.BatchOperation) error {
+	var tasks []*task
+	for start := 0; start < len(operations); start += size {
+		end := start + size
+		if end > len(operations) {
+			end = len(operations)
+		}
+		chunk := operations[start:end]
+		tasks = append(tasks, newTask(func(ctx context.Context) error {
+			return client.Batch(ctx, chunk)
+		}))
+	}
+	return runTasks(ctx, concurrency, tasks)
+}
+
+func logFailure(action, slug string, err error) {
+	log.Printf("Error %s document %s: %v", action, slug, err)
+	if hint := wordepress.ErrorHint(err); hint != "" {
+		log.Printf("Hint: %s", hint)
+	}
+}
+
+// publishBatched creates and updates documents using the batch API. Documents
+// are submitted a level of the hierarchy at a time, so that every parent has a
+// remote ID before its children are sent. A document that fails is reported
+// and its descendants skipped, but publishing otherwise continues.
+func publishBatched(ctx context.Context, client *wordepress.Client, size int,
+	localDocuments []*wordepress.Document, remoteDocuments map[*wordepress.Document]*wordepress.Document) error {
+
+	depth := make(map[*wordepress.Document]int)
+	var levels [][]*wordepress.Document
+	for _, localDocument := range localDocuments {
+		// Pre-order traversal guarantees the parent's depth is already known
+		if localDocument.LocalParent != nil {
+			depth[localDocument] = depth[localDocument.LocalParent] + 1
+		}
+		for len(levels) <= depth[localDocument] {
+			levels = append(levels, nil)
+		}
+		levels[depth[localDocument]] = append(levels[depth[localDocument]], localDocument)
+	}
+
+	failed := 0
+	for _, level := range levels {
+		var operations []*wordepress.BatchOperation
+		for _, localDocument := range level {
+			if localDocument.LocalParent != nil {
+				if localDocument.LocalParent.RemoteDocument == nil {
+					log.Printf("Skipping document %s: parent %s failed",
+						localDocument.Slug, localDocument.LocalParent.Slug)
+					failed++
+					continue
+				}
+				localDocument.Parent = localDocument.LocalParent.RemoteDocument.ID
+			}
+			localDocument.Recorded = wordepress.RecordModified
+
+			remoteDocument, exists := remoteDocuments[localDocument]
+			switch {
+			case !exists:
+				log.Printf("Uploading document: %s", localDocument.Slug)
+				operations = append(operations, &wordepress.BatchOperation{
+					Method:   "POST",
+					Document: localDocument})
+			case identical(client.Fields, localDocument, remoteDocument):
+				log.Printf("Skipping document: %s", localDocument.Slug)
+				localDocument.RemoteDocument = remoteDocument
+			case !overwritable(ctx, client, remoteDocument, "update"):
+				localDocument.RemoteDocument = remoteDocument
+			default:
+				log.Printf("Updating document: %s", localDocument.Slug)
+				operations = append(operations, &wordepress.BatchOperation{
+					Method:   "PUT",
+					ID:       remoteDocument.ID,
+					Document: localDocument})
+			}
+		}
+
+		if err := runBatches(ctx, client, size, operations); err != nil {
+			return err
+		}
+
+		for _, operation := range operations {
+			if operation.Err != nil {
+				action := "updating"
+				if operation.Method == "POST" {
+					action = "uploading"
+				}
+				logFailure(action, operation.Document.Slug, operation.Err)
+				failed++
+				continue
+			}
+			operation.Document.RemoteDocument = operation.Result
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d documents failed to publish", failed, len(localDocuments))
+	}
+	return nil
+}
+
+// deleteBatched deletes documents using the batch API, deepest first so that
+// children are removed before their parents. A document that fails is
+// reported and its ancestors kept, but deleting otherwise continues.
+func deleteBatched(ctx context.Context, client *wordepress.Client, size int, documents []*wordepress.Document) error {
+	byID := make(map[int]*wordepress.Document)
+	for _, document := range documents {
+		if document.Product != product || document.Tag != tag {
+			// meta_query filter was ignored, most likely due to wrong plugin version
+			log.Printf("Skipping delete of %s due to product/tag mismatch. "+
+				"Is your plugin up to date?", document.Slug)
+			continue
+		}
+		byID[document.ID] = document
+	}
+
+	var depth func(document *wordepress.Document) int
+	depth = func(document *wordepress.Document) int {
+		if parent, ok := byID[document.Parent]; ok && document.Parent != 0 {
+			return depth(parent) + 1
+		}
+		return 0
+	}
+
+	var levels [][]*wordepress.Document
+	for _, document := range documents {
+		if _, ok := byID[document.ID]; !ok {
+			continue
+		}
+		d := depth(document)
+		for len(levels) <= d {
+			levels = append(levels, nil)
+		}
+		levels[d] = append(levels[d], document)
+	}
+
+	failed := 0
+	// IDs of documents with a child that wasn't deleted
+	kept := make(map[int]bool)
+	for i := len(levels) - 1; i >= 0; i-- {
+		var operations []*wordepress.BatchOperation
+		for _, document := range levels[i] {
+			if kept[document.ID] {
+				log.Printf("Skipping delete of %s: a child failed to delete", document.Slug)
+				kept[document.Parent] = true
+				failed++
+				continue
+			}
+			log.Printf("Deleting document: %s", document.Slug)
+			operations = append(operations, &wordepress.BatchOperation{
+				Method:   "DELETE",
+				ID:       document.ID,
+				Document: document})
+		}
+
+		if err := runBatches(ctx, client, size, operations); err != nil {
+			return err
+		}
+		for _, operation := range operations {
+			if operation.Err != nil {
+				logFailure("deleting", operation.Document.Slug, operation.Err)
+				kept[operation.Document.Parent] = true
+				failed++
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d documents failed to delete", failed, len(byID))
+	}
+	return nil
+}
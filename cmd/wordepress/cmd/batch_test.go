@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/weaveworks/wordepress"
+	"github.com/weaveworks/wordepress/wordepresstest"
+)
+
+// recordBatches returns the method and path of each request made through the
+// batch API, in the order they were submitted
+func recordBatches(server *wordepresstest.Server) func() []string {
+	var mu sync.Mutex
+	var requests []string
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/batch/v1") {
+			body, _ := ioutil.ReadAll(r.Body)
+			r.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+			var batch struct {
+				Requests []struct{ Method, Path string }
+			}
+			json.Unmarshal(body, &batch)
+			mu.Lock()
+			for _, request := range batch.Requests {
+				requests = append(requests, request.Method+" "+request.Path)
+			}
+			mu.Unlock()
+		}
+		handler.ServeHTTP(w, r)
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestPublishBatched(t *testing.T) {
+	client, server := newTestClient(t)
+
+	newDocument := func(slug string, parent *wordepress.Document) *wordepress.Document {
+		return &wordepress.Document{Slug: slug, Status: "publish", LocalParent: parent,
+			Title: wordepress.Text{Raw: slug}, Content: wordepress.Text{Raw: "<p>" + slug + "</p>"}}
+	}
+	index := newDocument("index", nil)
+	install := newDocument("install", index)
+	kubernetes := newDocument("kubernetes", install)
+	broken := newDocument("broken", nil)
+	orphan := newDocument("orphan", broken)
+	faq := newDocument("faq", nil)
+
+	// Updating broken fails as it no longer exists in WordPress
+	remote := map[*wordepress.Document]*wordepress.Document{broken: {ID: 999, Slug: "broken"}}
+
+	// Pre-order, as parsed
+	documents := []*wordepress.Document{index, install, kubernetes, broken, orphan, faq}
+	err := publishBatched(context.Background(), client, 2, documents, remote)
+	if err == nil || err.Error() != "2 of 6 documents failed to publish" {
+		t.Errorf("got %v, want 2 of 6 documents failed", err)
+	}
+
+	// Each level is sent once its parents have IDs
+	for _, document := range []*wordepress.Document{install, kubernetes} {
+		if document.RemoteDocument == nil || document.RemoteDocument.Parent != document.LocalParent.RemoteDocument.ID {
+			t.Errorf("%s not published under %s", document.Slug, document.LocalParent.Slug)
+		}
+	}
+	// Publishing continues past the failure, but not below it
+	if faq.RemoteDocument == nil {
+		t.Errorf("faq not published")
+	}
+	if broken.RemoteDocument != nil || orphan.RemoteDocument != nil {
+		t.Errorf("broken and its child published")
+	}
+	slugs := make(map[string]bool)
+	for _, record := range server.Posts("documentation") {
+		slugs[record["slug"].(string)] = true
+	}
+	if len(slugs) != 4 || !slugs["index"] || !slugs["install"] || !slugs["kubernetes"] || !slugs["faq"] {
+		t.Errorf("published %v", slugs)
+	}
+}
+
+func TestDeleteBatched(t *testing.T) {
+	client, server := newTestClient(t)
+	batches := recordBatches(server)
+
+	oldProduct, oldTag := product, tag
+	t.Cleanup(func() { product, tag = oldProduct, oldTag })
+	product, tag = "net", "latest"
+
+	add := func(slug string, parent int) *wordepress.Document {
+		id := server.AddPost("documentation", wordepresstest.Record{"slug": slug, "parent": parent})
+		return &wordepress.Document{ID: id, Slug: slug, Parent: parent, Product: product, Tag: tag}
+	}
+	index := add("index", 0)
+	install := add("install", index.ID)
+	kubernetes := add("kubernetes", install.ID)
+	faq := add("faq", index.ID)
+	other := add("other", 0)
+	otherChild := add("other-child", other.ID)
+	// Already deleted from WordPress, so deleting it fails
+	gone := &wordepress.Document{ID: 999, Slug: "gone", Parent: kubernetes.ID, Product: product, Tag: tag}
+
+	documents := []*wordepress.Document{index, install, kubernetes, gone, faq, other, otherChild}
+	err := deleteBatched(context.Background(), client, 2, documents)
+	if err == nil || err.Error() != "4 of 7 documents failed to delete" {
+		t.Errorf("got %v, want 4 of 7 documents failed", err)
+	}
+
+	// The ancestors of the failure are kept, everything else deleted
+	kept := make(map[string]bool)
+	for _, record := range server.Posts("documentation") {
+		kept[record["slug"].(string)] = true
+	}
+	if len(kept) != 3 || !kept["index"] || !kept["install"] || !kept["kubernetes"] {
+		t.Errorf("kept %v, want index, install and kubernetes", kept)
+	}
+
+	// Children are deleted before their parents
+	order := make(map[string]int)
+	for i, request := range batches() {
+		order[request] = i + 1
+	}
+	for _, document := range []*wordepress.Document{faq, otherChild} {
+		child := order[fmt.Sprintf("DELETE /wp/v2/documentation/%d?force=true", document.ID)]
+		parent := order[fmt.Sprintf("DELETE /wp/v2/documentation/%d?force=true", document.Parent)]
+		if child == 0 || (parent != 0 && parent < child) {
+			t.Errorf("%s not deleted before its parent: %v", document.Slug, batches())
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+const configFileName = ".wordepress.yaml"
+
+var (
+	configPath    string
+	profile       string
+	passwordStdin bool
+
+	// sources records where the effective value of each setting came from,
+	// for the benefit of config show
+	sources = make(map[string]string)
+)
+
+// Flags that control configuration loading itself, and so can't be set from
+// the configuration
+var unconfigurable = map[string]bool{
+	"config":         true,
+	"profile":        true,
+	"password-stdin": true,
+	"help":           true,
+}
+
+// Settings whose values are redacted by config show
+var secret = map[string]bool{
+	"password": true,
+	"token":    true,
+}
+
+type configFile struct {
+	Settings map[string]interface{}            `yaml:",inline"`
+	Profiles map[string]map[string]interface{} `yaml:"profiles"`
+}
+
+// loadConfig fills in every flag not given on the command line, in order of
+// precedence from: --password-stdin, WORDEPRESS_* environment variables, the
+// selected profile of the configuration file, the top level of the
+// configuration file and finally ~/.netrc (user and password only)
+func loadConfig(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Changed {
+			sources[flag.Name] = "command line"
+		}
+	})
+
+	if passwordStdin {
+		if flags.Changed("password") {
+			return fmt.Errorf("--password and --password-stdin are mutually exclusive")
+		}
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("reading password from stdin: %v", err)
+		}
+		if err := setFlag(flags, "password", strings.TrimRight(line, "\r\n"), "stdin"); err != nil {
+			return err
+		}
+	}
+
+	if err := loadEnvironment(flags); err != nil {
+		return err
+	}
+
+	if profile == "" {
+		profile = os.Getenv("WORDEPRESS_PROFILE")
+	}
+	if err := loadConfigFile(cmd.Root(), flags); err != nil {
+		return err
+	}
+
+	return loadNetrc(flags)
+}
+
+func envName(flag string) string {
+	return "WORDEPRESS_" + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
+
+func loadEnvironment(flags *pflag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || unconfigurable[flag.Name] {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(flag.Name)); ok {
+			err = setFlag(flags, flag.Name, value, "$"+envName(flag.Name))
+		}
+	})
+	return err
+}
+
+func findConfigFile() (string, error) {
+	if configPath != "" {
+		return configPath, nil
+	}
+	if path := os.Getenv("WORDEPRESS_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	candidates := []string{configFileName}
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, configFileName))
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", nil
+}
+
+func loadConfigFile(root *cobra.Command, flags *pflag.FlagSet) error {
+	path, err := findConfigFile()
+	if err != nil || path == "" {
+		if profile != "" {
+			return fmt.Errorf("profile %s requested but no %s found", profile, configFileName)
+		}
+		return err
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var config configFile
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return fmt.Errorf("parsing %s: %v", path, err)
+	}
+
+	known := knownSettings(root)
+	sections := []map[string]interface{}{config.Settings}
+	descriptions := []string{path}
+	if profile != "" {
+		settings, ok := config.Profiles[profile]
+		if !ok {
+			return fmt.Errorf("profile %s not found in %s", profile, path)
+		}
+		sections = []map[string]interface{}{settings, config.Settings}
+		descriptions = []string{fmt.Sprintf("profile %s in %s", profile, path), path}
+	}
+
+	for i, settings := range sections {
+		var names []string
+		for name := range settings {
+			if !known[name] {
+				return fmt.Errorf("unknown setting %s in %s", name, path)
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if flags.Lookup(name) == nil {
+				// Valid, but not applicable to the command being run
+				continue
+			}
+			values, ok := settings[name].([]interface{})
+			if !ok {
+				values = []interface{}{settings[name]}
+			}
+			for _, value := range values {
+				if err := setFlag(flags, name, fmt.Sprint(value), descriptions[i]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// knownSettings returns the name of every flag of every command that may be
+// set from the configuration file
+func knownSettings(root *cobra.Command) map[string]bool {
+	known := make(map[string]bool)
+	var visit func(cmd *cobra.Command)
+	visit = func(cmd *cobra.Command) {
+		cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+			if !unconfigurable[flag.Name] {
+				known[flag.Name] = true
+			}
+		})
+		cmd.PersistentFlags().VisitAll(func(flag *pflag.Flag) {
+			if !unconfigurable[flag.Name] {
+				known[flag.Name] = true
+			}
+		})
+		for _, child := range cmd.Commands() {
+			visit(child)
+		}
+	}
+	visit(root)
+	return known
+}
+
+// setFlag applies value to the named flag unless a source of higher precedence
+// has already done so. Repeatable flags accumulate values from a single source.
+func setFlag(flags *pflag.FlagSet, name, value, source string) error {
+	if existing, ok := sources[name]; ok && existing != source {
+		return nil
+	}
+	if err := flags.Set(name, value); err != nil {
+		return fmt.Errorf("invalid %s from %s: %v", name, source, err)
+	}
+	sources[name] = source
+	return nil
+}
+
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// loadNetrc supplies user and password from the netrc entry for the host of
+// the WordPress URL, provided they haven't been configured elsewhere
+func loadNetrc(flags *pflag.FlagSet) error {
+	if flags.Lookup("password") == nil || sources["password"] != "" {
+		return nil
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil
+	}
+
+	path := netrcPath()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	login, netrcPassword, ok := parseNetrc(string(content), u.Hostname())
+	if !ok || (user != "" && login != "" && login != user) {
+		return nil
+	}
+
+	source := fmt.Sprintf("machine %s in %s", u.Hostname(), path)
+	if user == "" && login != "" {
+		if err := setFlag(flags, "user", login, source); err != nil {
+			return err
+		}
+	}
+	return setFlag(flags, "password", netrcPassword, source)
+}
+
+// parseNetrc returns the login and password of the entry for machine, falling
+// back to the default entry if there is one
+func parseNetrc(content, machine string) (string, string, bool) {
+	type entry struct{ login, password string }
+	var (
+		matched, fallback *entry
+		current           *entry
+	)
+
+	lines := strings.Split(content, "\n")
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		for j := 0; j < len(fields); j++ {
+			next := func() string {
+				if j+1 < len(fields) {
+					j++
+					return fields[j]
+				}
+				return ""
+			}
+			switch fields[j] {
+			case "machine":
+				current = &entry{}
+				if next() == machine && matched == nil {
+					matched = current
+				}
+			case "default":
+				current = &entry{}
+				fallback = current
+			case "login":
+				if current != nil {
+					current.login = next()
+				}
+			case "password":
+				if current != nil {
+					current.password = next()
+				}
+			case "account":
+				next()
+			case "macdef":
+				// Macro definitions run until the next blank line
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				j = len(fields)
+			}
+		}
+	}
+
+	if matched == nil {
+		matched = fallback
+	}
+	if matched == nil || matched.password == "" {
+		return "", "", false
+	}
+	return matched.login, matched.password, true
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect wordepress configuration",
+	Long:  `Inspect wordepress configuration`,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the effective configuration",
+	Long: `Show the effective configuration and where each setting came from, in
+order of precedence: command line, --password-stdin, WORDEPRESS_* environment
+variables, the selected --profile of ` + configFileName + `, the top level of
+` + configFileName + `, ~/.netrc and finally the built-in defaults. Secrets are
+redacted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			cmd.UsageFunc()(cmd)
+			os.Exit(1)
+		}
+
+		var lines [][]string
+		width := 0
+		cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+			if unconfigurable[flag.Name] {
+				return
+			}
+			value := flag.Value.String()
+			switch {
+			case secret[flag.Name] && value != "":
+				value = "<redacted>"
+			case flag.Name == "header":
+				value = redactHeaders(headers)
+			}
+			source := sources[flag.Name]
+			if source == "" {
+				source = "default"
+			}
+			if len(flag.Name) > width {
+				width = len(flag.Name)
+			}
+			lines = append(lines, []string{flag.Name, value, source})
+		})
+
+		for _, line := range lines {
+			fmt.Printf("%-*s  %s  (%s)\n", width+1, line[0]+":", line[1], line[2])
+		}
+	},
+}
+
+func redactHeaders(headers []string) string {
+	var redacted []string
+	for _, header := range headers {
+		name := strings.SplitN(header, ":", 2)[0]
+		redacted = append(redacted, name+": <redacted>")
+	}
+	return "[" + strings.Join(redacted, ", ") + "]"
+}
+
+func init() {
+	RootCmd.PersistentFlags().StringVarP(&configPath, "config", "", "", "Configuration file (default ./"+configFileName+" or ~/"+configFileName+")")
+	RootCmd.PersistentFlags().StringVarP(&profile, "profile", "", "", "Named profile to use from the configuration file")
+	RootCmd.PersistentFlags().BoolVarP(&passwordStdin, "password-stdin", "", false, "Read the password from standard input")
+
+	configCmd.AddCommand(configShowCmd)
+	RootCmd.AddCommand(configCmd)
+}
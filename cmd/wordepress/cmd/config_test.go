@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestParseNetrc(t *testing.T) {
+	content := `machine other.example.com login bob password hunter2
+
+machine docs.example.com
+	login alice
+	account ignored
+	password s3cret
+
+macdef init
+machine docs.example.com login mallory password wrong
+
+machine docs.example.com login carol password later
+default login anonymous password guest
+`
+	tests := []struct {
+		content, machine string
+		login, password  string
+		ok               bool
+	}{
+		{content, "docs.example.com", "alice", "s3cret", true},
+		{content, "other.example.com", "bob", "hunter2", true},
+		{content, "unknown.example.com", "anonymous", "guest", true},
+		{"machine docs.example.com login alice\n", "docs.example.com", "", "", false},
+		{"machine docs.example.com password only\n", "docs.example.com", "", "only", true},
+		{"macdef init\nmachine docs.example.com login alice password wrong\n\n", "docs.example.com", "", "", false},
+		{"", "docs.example.com", "", "", false},
+	}
+	for _, test := range tests {
+		login, password, ok := parseNetrc(test.content, test.machine)
+		if login != test.login || password != test.password || ok != test.ok {
+			t.Errorf("%s: got %q, %q, %v, want %q, %q, %v", test.machine, login, password, ok, test.login, test.password, test.ok)
+		}
+	}
+}
+
+// loadTestConfig loads the configuration of a command with the url, user,
+// password and token flags, as given by args
+func loadTestConfig(t *testing.T, args ...string) {
+	sources = make(map[string]string)
+	profile, configPath, passwordStdin = "", "", false
+
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().StringVar(&baseURL, "url", "http://wordpress.local", "")
+	cmd.Flags().StringVar(&user, "user", "", "")
+	cmd.Flags().StringVar(&password, "password", "", "")
+	cmd.Flags().StringVar(&token, "token", "", "")
+	cmd.Flags().StringVar(&profile, "profile", "", "")
+	cmd.Flags().StringVar(&configPath, "config", "", "")
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(cmd); err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("WORDEPRESS_CONFIG", filepath.Join(dir, "config.yaml"))
+	t.Setenv("NETRC", filepath.Join(dir, "netrc"))
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("config.yaml", "url: https://docs.example.com\nuser: top\ntoken: top-token\nprofiles:\n  staging:\n    user: staging\n")
+	write("netrc", "machine docs.example.com login top password from-netrc\n")
+
+	loadTestConfig(t)
+	if baseURL != "https://docs.example.com" || user != "top" || password != "from-netrc" || token != "top-token" {
+		t.Errorf("top level: url %s, user %s, password %s, token %s", baseURL, user, password, token)
+	}
+	if sources["password"] != "machine docs.example.com in "+filepath.Join(dir, "netrc") {
+		t.Errorf("password from %s", sources["password"])
+	}
+
+	// The profile overrides the top level, and the netrc login no longer
+	// matches
+	loadTestConfig(t, "--profile", "staging")
+	if user != "staging" || password != "" || token != "top-token" {
+		t.Errorf("profile: user %s, password %s, token %s", user, password, token)
+	}
+
+	t.Setenv("WORDEPRESS_USER", "env")
+	t.Setenv("WORDEPRESS_PASSWORD", "from-env")
+	loadTestConfig(t, "--profile", "staging")
+	if user != "env" || password != "from-env" || sources["user"] != "$WORDEPRESS_USER" {
+		t.Errorf("environment: user %s (from %s), password %s", user, sources["user"], password)
+	}
+
+	loadTestConfig(t, "--user", "flag", "--token", "flag-token")
+	if user != "flag" || token != "flag-token" || password != "from-env" || sources["user"] != "command line" {
+		t.Errorf("command line: user %s (from %s), token %s, password %s", user, sources["user"], token, password)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("WORDEPRESS_CONFIG", filepath.Join(dir, "config.yaml"))
+	for _, test := range []struct {
+		config string
+		args   []string
+		want   string
+	}{
+		{"usr: me\n", nil, "unknown setting usr in " + filepath.Join(dir, "config.yaml")},
+		{"user: me\n", []string{"--profile", "prod"}, "profile prod not found in " + filepath.Join(dir, "config.yaml")},
+		{"user: [me\n", nil, "parsing " + filepath.Join(dir, "config.yaml")},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "config.yaml"), []byte(test.config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		sources = make(map[string]string)
+		profile, configPath = "", ""
+		cmd := &cobra.Command{Use: "test"}
+		cmd.Flags().StringVar(&user, "user", "", "")
+		cmd.Flags().StringVar(&profile, "profile", "", "")
+		if err := cmd.ParseFlags(test.args); err != nil {
+			t.Fatal(err)
+		}
+		err := loadConfig(cmd)
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%q: got %v, want %s", test.config, err, test.want)
+		}
+	}
+}
@@ -1,10 +1,9 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"github.com/spf13/cobra"
-	"github.com/weaveworks/wordepress"
-	"log"
 	"os"
 )
 
@@ -13,45 +12,35 @@ var deleteCmd = &cobra.Command{
 	Short: "Delete a site from WordPress",
 	Long:  `Delete a site from WordPress`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if product == "" || tag == "" || user == "" || password == "" || len(args) > 0 {
+		if product == "" || tag == "" || !authConfigured() || len(args) > 0 {
 			cmd.UsageFunc()(cmd)
 			os.Exit(1)
 		}
 
-		endpoint := fmt.Sprintf("%s/wp-json/wp/v2/documentation", baseURL)
-		query := fmt.Sprintf(
-			"context=edit&per_page=100&"+
-				"filter[meta_query][0][key]=wpcf-product&"+
-				"filter[meta_query][0][value]=%s&"+
-				"filter[meta_query][1][key]=wpcf-tag&"+
-				"filter[meta_query][1][value]=%s", product, tag)
+		ctx := context.Background()
+		client := newClient()
+		query := fmt.Sprintf("context=edit&per_page=100&status=any&_fields=id,slug,parent,%s,%s&%s",
+			client.Fields.Product, client.Fields.Tag, client.ProductQuery(product, tag))
 
-		documents, err := wordepress.GetDocuments(user, password, endpoint, query)
+		documents, err := client.GetDocuments(ctx, query)
 		if err != nil {
-			log.Fatalf("Unable to get documents: %v", err)
+			fatal("Unable to get documents", err)
 		}
 
-		for _, document := range documents {
-			if document.Product != product || document.Tag != tag {
-				// meta_query filter was ignored, most likely due to wrong plugin version
-				log.Printf("Skipping delete of %s due to product/tag mismatch. "+
-					"Is your plugin up to date?", document.Slug)
-				continue
-			}
+		if err := deleteDocuments(ctx, client, batchSize(ctx, client), documents); err != nil {
+			fatal("Error deleting document", err)
+		}
 
-			if dryRun {
-				log.Printf("Would delete document: %s", document.Slug)
-			} else {
-				log.Printf("Deleting document: %s", document.Slug)
-				err := wordepress.DeleteDocument(user, password, endpoint, document)
-				if err != nil {
-					log.Fatalf("Error deleting document: %v", err)
-				}
+		if pruneMedia {
+			if err := pruneOrphanedMedia(ctx, client); err != nil {
+				fatal("Error deleting media", err)
 			}
 		}
 	},
 }
 
 func init() {
+	deleteCmd.Flags().BoolVarP(&pruneMedia, "prune-media", "", false, "Delete images no longer used by any document once deleted")
+	addPruneFlags(deleteCmd)
 	RootCmd.AddCommand(deleteCmd)
 }
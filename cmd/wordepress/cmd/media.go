@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/weaveworks/wordepress"
+)
+
+// Images are uploaded under their SHA1, so any such name found in a document
+// refers to an attachment
+var hashRegexp = regexp.MustCompile(`[0-9a-f]{40}`)
+
+var (
+	pruneMedia    bool
+	pruneMinAge   time.Duration
+	pruneMaxCount int
+)
+
+// uploadedByWordepress reports whether an attachment was uploaded for an image
+// by wordepress, rather than through the WordPress admin
+func uploadedByWordepress(media *wordepress.Media) bool {
+	return len(media.Slug) == 40 && hashRegexp.MatchString(media.Slug) &&
+		strings.HasPrefix(path.Base(media.MediaDetails.File), media.Slug)
+}
+
+// orphanedMedia returns the attachments uploaded by wordepress that aren't
+// referenced by any document of any product, tag or post type, ignoring those
+// uploaded too recently to rule out a publish still in progress
+func orphanedMedia(ctx context.Context, client *wordepress.Client) ([]*wordepress.Media, error) {
+	// Documents may have been published as any of the post types the
+	// plugin knows of, not just the one configured
+	restBases, err := client.PostTypes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting post types: %w", err)
+	}
+	if restBases == nil {
+		return nil, fmt.Errorf("unable to list the post types documents may be published as: "+
+			"upgrade the Wordepress plugin to %s", wordepress.PluginVersion)
+	}
+	listed := false
+	for _, restBase := range restBases {
+		listed = listed || restBase == client.RestBase
+	}
+	if !listed {
+		restBases = append(restBases, client.RestBase)
+	}
+
+	var documents []*wordepress.Document
+	for _, restBase := range restBases {
+		found, err := client.GetDocumentsOf(ctx, restBase, "context=edit&per_page=100&status=any&_fields=id,slug,content.raw,featured_media")
+		if err != nil {
+			return nil, fmt.Errorf("getting %s: %w", restBase, err)
+		}
+		documents = append(documents, found...)
+	}
+
+	// Featured images are only referenced by ID
+	referenced := make(map[string]bool)
+	featured := make(map[int]bool)
+	for _, document := range documents {
+		for _, hash := range hashRegexp.FindAllString(document.Content.Raw, -1) {
+			referenced[hash] = true
+		}
+		if document.FeaturedMedia != 0 {
+			featured[document.FeaturedMedia] = true
+		}
+	}
+
+	media, err := client.GetMedia(ctx, "per_page=100&_fields=id,slug,source_url,date_gmt,media_details")
+	if err != nil {
+		return nil, fmt.Errorf("getting media: %w", err)
+	}
+
+	var orphaned []*wordepress.Media
+	for _, m := range media {
+		if !uploadedByWordepress(m) || referenced[m.Slug] || featured[m.ID] {
+			continue
+		}
+		uploaded, err := time.Parse("2006-01-02T15:04:05", m.Date)
+		if err != nil || time.Since(uploaded) < pruneMinAge {
+			continue
+		}
+		orphaned = append(orphaned, m)
+	}
+	return orphaned, nil
+}
+
+// pruneOrphanedMedia deletes orphaned attachments, refusing if there are more
+// than --prune-max-count of them in case documents were missed
+func pruneOrphanedMedia(ctx context.Context, client *wordepress.Client) error {
+	orphaned, err := orphanedMedia(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	if pruneMaxCount > 0 && len(orphaned) > pruneMaxCount {
+		return fmt.Errorf("refusing to delete %d attachments, more than --prune-max-count %d",
+			len(orphaned), pruneMaxCount)
+	}
+
+	var tasks []*task
+	for _, media := range orphaned {
+		media := media
+		name := path.Base(media.MediaDetails.File)
+		if dryRun {
+			log.Printf("Would delete attachment: %s", name)
+			continue
+		}
+		tasks = append(tasks, newTask(func(ctx context.Context) error {
+			log.Printf("Deleting attachment: %s", name)
+			if err := client.DeleteMedia(ctx, media); err != nil {
+				return fmt.Errorf("deleting %s: %w", name, err)
+			}
+			return nil
+		}))
+	}
+	return runTasks(ctx, concurrency, tasks)
+}
+
+func addPruneFlags(cmd *cobra.Command) {
+	cmd.Flags().DurationVarP(&pruneMinAge, "prune-min-age", "", 24*time.Hour, "Only delete attachments uploaded at least this long ago")
+	cmd.Flags().IntVarP(&pruneMaxCount, "prune-max-count", "", 100, "Refuse to delete more than this many attachments (0 for no limit)")
+}
+
+var mediaCmd = &cobra.Command{
+	Use:   "media",
+	Short: "Manage images uploaded to WordPress",
+	Long:  `Manage images uploaded to WordPress`,
+}
+
+var mediaGcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Delete images no longer used by any document",
+	Long: `Delete the images uploaded by wordepress that are no longer referenced by
+any document of any product or tag`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !authConfigured() || len(args) > 0 {
+			cmd.UsageFunc()(cmd)
+			os.Exit(1)
+		}
+
+		ctx := context.Background()
+		if err := pruneOrphanedMedia(ctx, newClient()); err != nil {
+			fatal("Error deleting media", err)
+		}
+	},
+}
+
+func init() {
+	addPruneFlags(mediaGcCmd)
+	mediaCmd.AddCommand(mediaGcCmd)
+	RootCmd.AddCommand(mediaCmd)
+}
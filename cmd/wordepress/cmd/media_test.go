@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/wordepress"
+	"github.com/weaveworks/wordepress/wordepresstest"
+)
+
+func newTestClient(t *testing.T) (*wordepress.Client, *wordepresstest.Server) {
+	server := wordepresstest.NewServer()
+	t.Cleanup(server.Close)
+	client := wordepress.NewClient(server.URL, nil)
+	client.Retry.MaxAttempts = 1
+	return client, server
+}
+
+// uploadTestImage uploads an image with the given content as publish would
+func uploadTestImage(t *testing.T, client *wordepress.Client, content string) *wordepress.Media {
+	filename := filepath.Join(t.TempDir(), "image.png")
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	image, err := wordepress.ReadImage(filename, wordepress.ImageOptions{})
+	if err != nil {
+		t.Fatalf("ReadImage: %v", err)
+	}
+	media, err := client.PostImage(context.Background(), image)
+	if err != nil {
+		t.Fatalf("PostImage: %v", err)
+	}
+	return media
+}
+
+// setPruneFlags sets the flags of media gc for the duration of a test
+func setPruneFlags(t *testing.T, minAge time.Duration, maxCount int, dry bool) {
+	oldMinAge, oldMaxCount, oldDryRun := pruneMinAge, pruneMaxCount, dryRun
+	t.Cleanup(func() { pruneMinAge, pruneMaxCount, dryRun = oldMinAge, oldMaxCount, oldDryRun })
+	pruneMinAge, pruneMaxCount, dryRun = minAge, maxCount, dry
+}
+
+// age makes an attachment look as though it was uploaded a day ago
+func age(server *wordepresstest.Server, media *wordepress.Media) {
+	uploaded := time.Now().UTC().Add(-24 * time.Hour).Format("2006-01-02T15:04:05")
+	server.EditPost("media", media.ID, wordepresstest.APIUser, wordepresstest.Record{"date_gmt": uploaded})
+}
+
+// remaining returns the IDs of the attachments left in the media library
+func remaining(server *wordepresstest.Server) map[int]bool {
+	ids := make(map[int]bool)
+	for _, record := range server.Posts("media") {
+		ids[int(record["id"].(float64))] = true
+	}
+	return ids
+}
+
+func TestPruneOrphanedMedia(t *testing.T) {
+	setPruneFlags(t, time.Hour, 100, false)
+	client, server := newTestClient(t)
+	server.PostTypes = []string{"documentation", "pages"}
+
+	inContent := uploadTestImage(t, client, "in content")
+	onPage := uploadTestImage(t, client, "on another post type")
+	orphan := uploadTestImage(t, client, "orphan")
+	recent := uploadTestImage(t, client, "too recent")
+	for _, media := range []*wordepress.Media{inContent, onPage, orphan} {
+		age(server, media)
+	}
+	server.AddPost(wordepress.DefaultRestBase, wordepresstest.Record{"slug": "install",
+		"content": `<p><img src="` + inContent.SourceURL + `" /></p>`})
+	server.AddPost("pages", wordepresstest.Record{"slug": "about",
+		"content": `<p><img src="` + onPage.SourceURL + `" /></p>`})
+	// Uploaded through the admin rather than by wordepress
+	server.AddPost("media", wordepresstest.Record{"slug": "logo", "date_gmt": "2020-01-01T00:00:00",
+		"media_details": map[string]interface{}{"file": "logo.png"}})
+
+	if err := pruneOrphanedMedia(context.Background(), client); err != nil {
+		t.Fatalf("pruneOrphanedMedia: %v", err)
+	}
+	ids := remaining(server)
+	if len(ids) != 4 || ids[orphan.ID] || !ids[inContent.ID] || !ids[onPage.ID] || !ids[recent.ID] {
+		t.Errorf("left %v, want all but the orphan %d", ids, orphan.ID)
+	}
+}
+
+func TestPruneOrphanedMediaLimits(t *testing.T) {
+	client, server := newTestClient(t)
+	for _, content := range []string{"one", "two", "three"} {
+		age(server, uploadTestImage(t, client, content))
+	}
+
+	setPruneFlags(t, time.Hour, 2, false)
+	err := pruneOrphanedMedia(context.Background(), client)
+	if err == nil || len(remaining(server)) != 3 {
+		t.Errorf("over --prune-max-count: got %v leaving %d attachments, want an error and none deleted", err, len(remaining(server)))
+	}
+
+	setPruneFlags(t, time.Hour, 0, true)
+	if err := pruneOrphanedMedia(context.Background(), client); err != nil || len(remaining(server)) != 3 {
+		t.Errorf("dry run: got %v leaving %d attachments, want none deleted", err, len(remaining(server)))
+	}
+
+	setPruneFlags(t, time.Hour, 3, false)
+	if err := pruneOrphanedMedia(context.Background(), client); err != nil || len(remaining(server)) != 0 {
+		t.Errorf("got %v leaving %d attachments, want all deleted", err, len(remaining(server)))
+	}
+}
+
+func TestPruneOrphanedMediaOldPlugin(t *testing.T) {
+	setPruneFlags(t, 0, 0, false)
+	client, server := newTestClient(t)
+	server.PostTypes = nil
+	uploadTestImage(t, client, "orphan")
+
+	// Without knowing every post type, anything might be in use
+	if err := pruneOrphanedMedia(context.Background(), client); err == nil || len(remaining(server)) != 1 {
+		t.Errorf("got %v, want an error and nothing deleted", err)
+	}
+}
+
+func TestOrphanedMediaFeatured(t *testing.T) {
+	setPruneFlags(t, 0, 0, false)
+	client, server := newTestClient(t)
+
+	featured := uploadTestImage(t, client, "featured")
+	orphan := uploadTestImage(t, client, "orphan")
+	server.AddPost(wordepress.DefaultRestBase, wordepresstest.Record{"slug": "install", "featured_media": featured.ID})
+
+	orphaned, err := orphanedMedia(context.Background(), client)
+	if err != nil {
+		t.Fatalf("orphanedMedia: %v", err)
+	}
+	if len(orphaned) != 1 || orphaned[0].ID != orphan.ID {
+		t.Errorf("orphaned %v, want only attachment %d", orphaned, orphan.ID)
+	}
+}
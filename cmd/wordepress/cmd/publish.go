@@ -1,91 +1,304 @@
 package cmd
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/weaveworks/wordepress"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
+	"sync/atomic"
 )
 
 var (
-	version string
+	version           string
+	force             bool
+	imageOptions      wordepress.ImageOptions
+	assetOptions      wordepress.AssetOptions
+	numberedFilenames bool
+
+	// Number of documents left untouched because they were modified in
+	// WordPress
+	drifted int32
 )
 
-func headImage(image *wordepress.Image) (bool, error) {
-	url := baseURL + "/wp-content/uploads/" + image.Hash + image.Extension
-	request, err := http.NewRequest("HEAD", url, nil)
+func toMap(rds []*wordepress.Document) map[string]*wordepress.Document {
+	rdm := make(map[string]*wordepress.Document)
+	for i, _ := range rds {
+		rdm[rds[i].Slug] = rds[i]
+	}
+	return rdm
+}
 
-	client := &http.Client{}
-	response, err := client.Do(request)
-	if err != nil {
-		return false, err
+// sameIDs reports whether a and b hold the same IDs, in any order and however
+// often
+func sameIDs(a, b []int) bool {
+	set := make(map[int]bool)
+	for _, id := range b {
+		set[id] = true
+	}
+	seen := make(map[int]bool)
+	for _, id := range a {
+		if !set[id] {
+			return false
+		}
+		seen[id] = true
 	}
-	response.Body.Close()
+	return len(seen) == len(set)
+}
 
-	if response.StatusCode != http.StatusOK {
-		return false, nil
+// identical reports whether publishing a document would leave it unchanged,
+// comparing only the fields configured
+func identical(fields wordepress.FieldNames, local *wordepress.Document, remote *wordepress.Document) bool {
+	// WordPress schedules documents published with a future date
+	remoteStatus := remote.Status
+	if remoteStatus == "future" {
+		remoteStatus = "publish"
 	}
-	return true, nil
+
+	return local.MenuOrder == remote.MenuOrder &&
+		local.Title.Raw == remote.Title.Raw &&
+		local.SameContent(remote.Content.Raw) &&
+		local.Excerpt.Raw == remote.Excerpt.Raw &&
+		local.Parent == remote.Parent &&
+		local.Status == remoteStatus &&
+		(local.Author == 0 || local.Author == remote.Author) &&
+		(local.Date == "" || local.Date == remote.Date) &&
+		(local.CommentStatus == "" || local.CommentStatus == remote.CommentStatus) &&
+		(local.Template == "" || local.Template == remote.Template) &&
+		(local.FeaturedMedia == 0 || local.FeaturedMedia == remote.FeaturedMedia) &&
+		(local.Categories == nil || sameIDs(local.Categories, remote.Categories)) &&
+		(local.Tags == nil || sameIDs(local.Tags, remote.Tags)) &&
+		local.SameMeta(remote) &&
+		(fields.Version == "" || local.Version == remote.Version) &&
+		(fields.Link == "" || local.Link == remote.Link) &&
+		(fields.Checksum == "" || remote.Unmodified())
 }
 
-func postImage(image *wordepress.Image) error {
-	url := baseURL + "/wp-json/wp/v2/media"
-	name := image.Hash + image.Extension
-	request, err := http.NewRequest("POST", url, bytes.NewReader(image.Content))
-	request.SetBasicAuth(user, password)
-	request.Header.Set("Content-Type", image.MimeType)
-	request.Header.Set("Content-Disposition", `attachment; filename="`+name+`"`)
-	request.Header.Set("Accept", "application/json")
-
-	client := &http.Client{}
-	response, err := client.Do(request)
-	if err != nil {
-		return err
+// resolveProperties looks up the IDs of the authors, categories and tags named
+// by documents, creating missing categories and tags
+func resolveProperties(ctx context.Context, client *wordepress.Client, documents []*wordepress.Document) error {
+	authors := make(map[string]int)
+	for _, document := range documents {
+		if document.AuthorSlug == "" {
+			continue
+		}
+		id, ok := authors[document.AuthorSlug]
+		if !ok {
+			var err error
+			id, err = client.UserID(ctx, document.AuthorSlug)
+			if err != nil {
+				return fmt.Errorf("author of %s: %w", document.Slug, err)
+			}
+			authors[document.AuthorSlug] = id
+		}
+		document.Author = id
+	}
+
+	for taxonomy, singular := range map[string]string{"categories": "category", "tags": "tag"} {
+		terms := func(document *wordepress.Document) ([]string, *[]int) {
+			if taxonomy == "categories" {
+				return document.CategoryNames, &document.Categories
+			}
+			return document.TagNames, &document.Tags
+		}
+
+		var names []string
+		for _, document := range documents {
+			documentNames, _ := terms(document)
+			names = append(names, documentNames...)
+		}
+		ids, err := client.TermIDs(ctx, taxonomy, names, !dryRun)
+		if err != nil {
+			return err
+		}
+
+		missing := make(map[string]bool)
+		for _, document := range documents {
+			documentNames, documentIDs := terms(document)
+			// Names differing only in case or punctuation share a term
+			added := make(map[int]bool)
+			for _, name := range documentNames {
+				id, ok := ids[name]
+				if !ok {
+					if !missing[name] {
+						log.Printf("Would create %s: %s", singular, name)
+						missing[name] = true
+					}
+					continue
+				}
+				if !added[id] {
+					added[id] = true
+					*documentIDs = append(*documentIDs, id)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// overwritable reports whether a remote document may be updated or deleted,
+// which is refused without --force if it has been modified in WordPress since
+// it was last published
+func overwritable(ctx context.Context, client *wordepress.Client, remoteDocument *wordepress.Document, action string) bool {
+	if client.Fields.Checksum == "" || !remoteDocument.Drifted() {
+		return true
 	}
 
-	responseBytes, err := ioutil.ReadAll(response.Body)
-	response.Body.Close()
+	editor, err := client.LastEditor(ctx, remoteDocument.ID)
 	if err != nil {
-		return err
+		log.Printf("Unable to determine who modified %s: %v", remoteDocument.Slug, err)
+	}
+	if editor == "" {
+		editor = "an unknown user"
+	}
+
+	if force {
+		log.Printf("Overwriting document %s modified in WordPress at %s UTC by %s",
+			remoteDocument.Slug, remoteDocument.Modified, editor)
+		return true
 	}
+	log.Printf("Refusing to %s document %s: modified in WordPress at %s UTC by %s",
+		action, remoteDocument.Slug, remoteDocument.Modified, editor)
+	atomic.AddInt32(&drifted, 1)
+	return false
+}
 
-	if response.StatusCode != http.StatusCreated {
-		return fmt.Errorf("post failed: %v %s", response.Status, string(responseBytes))
+// checkFields fails unless the schema of the post type describes the checksum
+// field, and the link field if any document is a link, before anything is
+// written. Otherwise WordPress ignores them and every publish updates every
+// document.
+func checkFields(ctx context.Context, client *wordepress.Client, localDocuments []*wordepress.Document) error {
+	type check struct{ name, flag string }
+	var checks []check
+	if client.Fields.Checksum != "" {
+		checks = append(checks, check{client.Fields.Checksum, "--checksum-field"})
+	}
+	for _, localDocument := range localDocuments {
+		if localDocument.Link != "" && client.Fields.Link != "" {
+			checks = append(checks, check{client.Fields.Link, "--link-field"})
+			break
+		}
+	}
+	if len(checks) == 0 {
+		return nil
 	}
 
-	var media wordepress.Media
-	err = json.Unmarshal(responseBytes, &media)
+	fields, err := client.SchemaFields(ctx)
 	if err != nil {
 		return err
 	}
+	for _, check := range checks {
+		switch {
+		case fields[check.name]:
+		case wordepress.MetaKey(check.name) != check.name:
+			return fmt.Errorf("%s isn't registered for the post type: register the meta key with show_in_rest, or pass %s \"\"",
+				check.name, check.flag)
+		default:
+			return fmt.Errorf("%s isn't registered for the post type: install version %s or later of the Wordepress plugin and add the post type to its wordepress_post_types filter, or pass %s \"\"",
+				check.name, wordepress.PluginVersion, check.flag)
+		}
+	}
+	return nil
+}
 
-	if media.MediaDetails.File != name {
-		return fmt.Errorf("duplicate attachment: requested %s, response %s",
-			name, media.MediaDetails.File)
+func publishDocument(ctx context.Context, client *wordepress.Client, localDocument, remoteDocument *wordepress.Document, exists bool) error {
+	if localDocument.LocalParent != nil {
+		localDocument.Parent = localDocument.LocalParent.RemoteDocument.ID
+	}
+	localDocument.Recorded = wordepress.RecordModified
+
+	if !exists {
+		if dryRun {
+			log.Printf("Would upload document: %s", localDocument.Slug)
+			localDocument.RemoteDocument = &wordepress.Document{}
+			return nil
+		}
+		log.Printf("Uploading document: %s", localDocument.Slug)
+		remoteDocument, err := client.PostDocument(ctx, localDocument)
+		if err != nil {
+			return fmt.Errorf("uploading %s: %w", localDocument.Slug, err)
+		}
+		localDocument.RemoteDocument = remoteDocument
+		return nil
 	}
 
+	if identical(client.Fields, localDocument, remoteDocument) {
+		if dryRun {
+			log.Printf("Would skip document: %s", localDocument.Slug)
+		} else {
+			log.Printf("Skipping document: %s", localDocument.Slug)
+		}
+	} else if overwritable(ctx, client, remoteDocument, "update") {
+		if dryRun {
+			log.Printf("Would update document: %s", localDocument.Slug)
+		} else {
+			log.Printf("Updating document: %s", localDocument.Slug)
+			var err error
+			remoteDocument, err = client.PutDocument(ctx, remoteDocument.ID, localDocument)
+			if err != nil {
+				return fmt.Errorf("updating %s: %w", localDocument.Slug, err)
+			}
+		}
+	}
+	localDocument.RemoteDocument = remoteDocument
 	return nil
 }
 
-func toMap(rds []*wordepress.Document) map[string]*wordepress.Document {
-	rdm := make(map[string]*wordepress.Document)
-	for i, _ := range rds {
-		rdm[rds[i].Slug] = rds[i]
+func publishImage(ctx context.Context, client *wordepress.Client, image *wordepress.Image) error {
+	media, err := client.FindImage(ctx, image)
+	if err != nil {
+		return fmt.Errorf("testing existence of %s: %w", image.Name(), err)
 	}
-	return rdm
+	if media != nil {
+		if dryRun {
+			log.Printf("Would skip image: %s", image.Name())
+		} else {
+			log.Printf("Skipping image: %s", image.Name())
+		}
+		image.Media = media
+		return nil
+	}
+	if dryRun {
+		log.Printf("Would upload image: %s", image.Name())
+		return nil
+	}
+	log.Printf("Uploading image: %s", image.Name())
+	media, err = client.PostImage(ctx, image)
+	if err != nil {
+		return fmt.Errorf("uploading %s: %w", image.Name(), err)
+	}
+	image.Media = media
+	return nil
 }
 
-func identical(local *wordepress.Document, remote *wordepress.Document) bool {
-	return local.MenuOrder == remote.MenuOrder &&
-		local.Title.Raw == remote.Title.Raw &&
-		local.Content.Raw == remote.Content.Raw &&
-		local.Parent == remote.Parent &&
-		local.Version == remote.Version
+// attachImages attaches each image not yet attached to a post to the first
+// document that references it
+func attachImages(ctx context.Context, client *wordepress.Client, localDocuments []*wordepress.Document, uploaded map[string]*wordepress.Image) error {
+	var tasks []*task
+	attached := make(map[string]bool)
+	for _, localDocument := range localDocuments {
+		if localDocument.RemoteDocument == nil || localDocument.RemoteDocument.ID == 0 {
+			continue
+		}
+		for _, reference := range localDocument.Images {
+			image := uploaded[reference.Name()]
+			if attached[image.Name()] || image.Media == nil || image.Media.Post != 0 {
+				continue
+			}
+			attached[image.Name()] = true
+
+			localDocument := localDocument
+			tasks = append(tasks, newTask(func(ctx context.Context) error {
+				log.Printf("Attaching image %s to %s", image.Name(), localDocument.Slug)
+				if err := client.AttachImage(ctx, image.Media, localDocument.RemoteDocument.ID); err != nil {
+					return fmt.Errorf("attaching %s: %w", image.Name(), err)
+				}
+				return nil
+			}))
+		}
+	}
+	return runTasks(ctx, concurrency, tasks)
 }
 
 var publishCmd = &cobra.Command{
@@ -93,121 +306,167 @@ var publishCmd = &cobra.Command{
 	Short: "Publish a site into WordPress",
 	Long:  `Publish a site into WordPress`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if product == "" || tag == "" || version == "" || user == "" || password == "" || len(args) != 1 {
+		if product == "" || tag == "" || version == "" || !authConfigured() || len(args) != 1 {
 			cmd.UsageFunc()(cmd)
 			os.Exit(1)
 		}
 
+		if imageOptions.Quality < 0 || imageOptions.Quality > 100 || imageOptions.MaxWidth < 0 {
+			log.Fatalf("Invalid image options: quality must be between 1 and 100, and width positive")
+		}
+
+		// The client declares the custom fields allowed in front matter
+		client := newClient()
+
 		// Load local site
-		localDocuments, images, err := wordepress.ParseSite(product, version, tag, args[0])
+		options := wordepress.ParseOptions{Images: imageOptions, Assets: assetOptions, Meta: client.Fields.Meta, NumberedFilenames: numberedFilenames}
+		localDocuments, images, err := wordepress.ParseSite(product, version, tag, args[0], options)
 		if err != nil {
 			log.Fatalf("Error parsing site: %v", err)
 		}
 
+		ctx := context.Background()
+		if err := checkFields(ctx, client, localDocuments); err != nil {
+			fatal("Unable to publish", err)
+		}
+
 		// Load remote site. context=edit is required to populate the Raw field
 		// of the title and content JSON for comparison with local values
-		endpoint := fmt.Sprintf("%s/wp-json/wp/v2/documentation", baseURL)
-		query := fmt.Sprintf(
-			"context=edit&per_page=100&"+
-				"filter[meta_query][0][key]=wpcf-product&"+
-				"filter[meta_query][0][value]=%s&"+
-				"filter[meta_query][1][key]=wpcf-tag&"+
-				"filter[meta_query][1][value]=%s", product, tag)
-
-		remoteDocuments, err := wordepress.GetDocuments(user, password, endpoint, query)
+		query := "context=edit&per_page=100&status=any&" + client.ProductQuery(product, tag)
+
+		remoteDocuments, err := client.GetDocuments(ctx, query)
 		if err != nil {
-			log.Fatalf("Unable to get JSON documents: %v", err)
+			fatal("Unable to get JSON documents", err)
 		}
 
-		// Create/update documents
+		// Match local documents with their existing remote counterparts
 		existing := toMap(remoteDocuments)
+		remote := make(map[*wordepress.Document]*wordepress.Document)
 		for _, localDocument := range localDocuments {
-			if localDocument.LocalParent != nil {
-				// Pre-order traversal guarantees the remote document will be set
-				localDocument.Parent = localDocument.LocalParent.RemoteDocument.ID
-			}
 			if remoteDocument, ok := existing[localDocument.Slug]; ok {
-				if identical(localDocument, remoteDocument) {
-					if dryRun {
-						log.Printf("Would skip document: %s", localDocument.Slug)
-					} else {
-						log.Printf("Skipping document: %s", localDocument.Slug)
-					}
-				} else {
-					if dryRun {
-						log.Printf("Would update document: %s", localDocument.Slug)
-					} else {
-						log.Printf("Updating document: %s", localDocument.Slug)
-						remoteDocument, err = wordepress.PutDocument(user, password, endpoint, remoteDocument.ID, localDocument)
-						if err != nil {
-							log.Fatalf("Error updating document: %v", err)
-						}
-					}
-				}
-				localDocument.RemoteDocument = remoteDocument
+				remote[localDocument] = remoteDocument
 				delete(existing, localDocument.Slug)
-			} else {
-				if dryRun {
-					log.Printf("Would upload document: %s", localDocument.Slug)
-					localDocument.RemoteDocument = &wordepress.Document{}
-				} else {
-					log.Printf("Uploading document: %s", localDocument.Slug)
-					remoteDocument, err := wordepress.PostDocument(user, password, endpoint, localDocument)
-					if err != nil {
-						log.Fatalf("Error uploading document: %v", err)
-					}
-					localDocument.RemoteDocument = remoteDocument
-				}
 			}
 		}
 
-		// Upload new images
+		// Upload new images first, so that documents can be rewritten to
+		// refer to wherever WordPress put them. The same image may be
+		// referenced by several documents, so only schedule each distinct one
+		// once to avoid racing duplicate uploads
+		var imageTasks []*task
+		uploaded := make(map[string]*wordepress.Image)
 		for _, image := range images {
-			exists, err := headImage(image)
-			if err != nil {
-				log.Fatalf("Error testing image existence: %v", err)
-			}
-			if exists {
-				if dryRun {
-					log.Printf("Would skip image: %s%s", image.Hash, image.Extension)
-				} else {
-					log.Printf("Skipping image: %s%s", image.Hash, image.Extension)
-				}
+			image := image
+			if uploaded[image.Name()] != nil {
 				continue
 			}
-			if dryRun {
-				log.Printf("Would upload image: %s%s", image.Hash, image.Extension)
-			} else {
-				log.Printf("Uploading image: %s%s", image.Hash, image.Extension)
-				err = postImage(image)
-				if err != nil {
-					log.Fatalf("Error uploading image: %v", err)
+			uploaded[image.Name()] = image
+			imageTasks = append(imageTasks, newTask(func(ctx context.Context) error {
+				return publishImage(ctx, client, image)
+			}))
+		}
+
+		// Find existing uploads in bulk rather than one image at a time
+		if err := client.LookupImages(ctx, images); err != nil {
+			fatal("Unable to get media", err)
+		}
+
+		if err := runTasks(ctx, concurrency, imageTasks); err != nil {
+			fatal("Error publishing images", err)
+		}
+
+		urls := make(map[string]string)
+		for name, image := range uploaded {
+			if image.Media != nil {
+				urls[name] = image.Media.SourceURL
+			}
+		}
+		for _, localDocument := range localDocuments {
+			localDocument.ResolveImages(urls)
+			if featured := localDocument.FeaturedImage; featured != nil && featured.Media != nil {
+				localDocument.FeaturedMedia = featured.Media.ID
+			}
+		}
+
+		if err := resolveProperties(ctx, client, localDocuments); err != nil {
+			fatal("Error resolving document properties", err)
+		}
+
+		// Create/update documents. Unless they're batched, each document is
+		// handled by a task that waits for its parent's task, guaranteeing
+		// that the parent's remote document (and thus its ID) is set before
+		// the child is uploaded
+		size := batchSize(ctx, client)
+		if size > 0 {
+			if err := publishBatched(ctx, client, size, localDocuments, remote); err != nil {
+				fatal("Error publishing site", err)
+			}
+		} else {
+			var tasks []*task
+			documentTasks := make(map[*wordepress.Document]*task)
+			for _, localDocument := range localDocuments {
+				localDocument := localDocument
+				remoteDocument, ok := remote[localDocument]
+
+				t := newTask(func(ctx context.Context) error {
+					return publishDocument(ctx, client, localDocument, remoteDocument, ok)
+				})
+				if localDocument.LocalParent != nil {
+					t.after(documentTasks[localDocument.LocalParent])
 				}
+				documentTasks[localDocument] = t
+				tasks = append(tasks, t)
+			}
+
+			if err := runTasks(ctx, concurrency, tasks); err != nil {
+				fatal("Error publishing site", err)
+			}
+		}
+
+		if !dryRun {
+			if err := attachImages(ctx, client, localDocuments, uploaded); err != nil {
+				fatal("Error attaching images", err)
 			}
 		}
 
 		// Remove residual remote documents
+		var residual []*wordepress.Document
 		for _, remoteDocument := range existing {
-			if remoteDocument.Product != product || remoteDocument.Tag != tag {
-				// meta_query filter was ignored, most likely due to wrong plugin version
-				log.Printf("Skipping delete of %s due to product/tag mismatch. "+
-					"Is your plugin up to date?", remoteDocument.Slug)
-				continue
+			if overwritable(ctx, client, remoteDocument, "delete") {
+				residual = append(residual, remoteDocument)
 			}
-			if dryRun {
-				log.Printf("Would delete document: %s", remoteDocument.Slug)
-			} else {
-				log.Printf("Deleting document: %s", remoteDocument.Slug)
-				err := wordepress.DeleteDocument(user, password, endpoint, remoteDocument)
-				if err != nil {
-					log.Fatalf("Error deleting document: %v", err)
-				}
+		}
+		if err := deleteDocuments(ctx, client, size, residual); err != nil {
+			fatal("Error deleting document", err)
+		}
+
+		if pruneMedia {
+			if err := pruneOrphanedMedia(ctx, client); err != nil {
+				fatal("Error deleting media", err)
 			}
 		}
+
+		if drifted > 0 {
+			log.Fatalf("%d documents have been modified in WordPress since they were last published. "+
+				"Incorporate the changes into the source, or rerun with --force to overwrite them", drifted)
+		}
 	},
 }
 
 func init() {
 	publishCmd.Flags().StringVarP(&version, "version", "", "", "Value for document version field")
+	publishCmd.Flags().BoolVarP(&force, "force", "", false, "Overwrite documents modified in WordPress since they were last published")
+	publishCmd.Flags().BoolVarP(&numberedFilenames, "numbered-filenames", "", false, "Order pages without a menu_order by the number prefixed to their filename (e.g. 01-install.md), dropped from their slug")
+	publishCmd.Flags().BoolVarP(&pruneMedia, "prune-media", "", false, "Delete images no longer used by any document once published")
+	addPruneFlags(publishCmd)
+	publishCmd.Flags().IntVarP(&imageOptions.MaxWidth, "image-max-width", "", 0, "Downscale PNG and JPEG images wider than this (0 for no limit)")
+	publishCmd.Flags().IntVarP(&imageOptions.Quality, "image-quality", "", 0, "Re-encode JPEG images at this quality (1-100) and PNG images with maximum compression")
+	publishCmd.Flags().BoolVarP(&imageOptions.StripMetadata, "image-strip-metadata", "", false, "Remove EXIF and other metadata from PNG and JPEG images")
+	publishCmd.Flags().BoolVarP(&imageOptions.Dimensions, "image-dimensions", "", false, "Add width and height attributes to images")
+	publishCmd.Flags().IntSliceVarP(&imageOptions.SrcsetWidths, "image-srcset", "", nil, "Widths of downscaled copies of PNG and JPEG images to offer via srcset, e.g. 480,960")
+	publishCmd.Flags().StringVarP(&imageOptions.Sizes, "image-sizes", "", "", "Sizes attribute for images with a srcset (default the image's width)")
+	publishCmd.Flags().IntVarP(&imageOptions.InlineSVGMaxSize, "image-inline-svg", "", 0, "Inline SVGs of up to this many bytes into documents instead of uploading them")
+	publishCmd.Flags().StringSliceVarP(&assetOptions.Extensions, "asset-extensions", "", []string{"pdf", "yaml", "yml", "json", "txt", "zip", "gz", "tgz"}, "Extensions of linked files to upload alongside images")
+	publishCmd.Flags().Int64VarP(&assetOptions.MaxSize, "asset-max-size", "", 20<<20, "Refuse to upload linked files larger than this many bytes (0 for no limit)")
 	RootCmd.AddCommand(publishCmd)
 }
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/weaveworks/wordepress"
+	"github.com/weaveworks/wordepress/wordepresstest"
+)
+
+func TestIdenticalFields(t *testing.T) {
+	local := &wordepress.Document{Title: wordepress.Text{Raw: "Install"}, Version: "1.1", Status: "publish"}
+	remote := &wordepress.Document{Title: wordepress.Text{Raw: "Install"}, Version: "1.0", Status: "publish",
+		Recorded: "2021-03-04T08:00:00", Modified: "2021-03-04T09:00:00"}
+
+	// Fields that aren't configured aren't compared
+	tests := []struct {
+		fields    wordepress.FieldNames
+		identical bool
+	}{
+		{wordepress.FieldNames{Version: "wpcf-version", Checksum: "wordepress-checksum"}, false},
+		{wordepress.FieldNames{Checksum: "wordepress-checksum"}, false},
+		{wordepress.FieldNames{Version: "wpcf-version"}, false},
+		{wordepress.FieldNames{}, true},
+	}
+	for _, test := range tests {
+		if identical(test.fields, local, remote) != test.identical {
+			t.Errorf("%+v: identical %v", test.fields, !test.identical)
+		}
+	}
+}
+
+func TestSameIDs(t *testing.T) {
+	tests := []struct {
+		a, b []int
+		same bool
+	}{
+		{[]int{1, 2}, []int{2, 1}, true},
+		{[]int{1, 1}, []int{1}, true},
+		{[]int{1}, []int{1, 1}, true},
+		{[]int{1, 1}, []int{1, 2}, false},
+		{[]int{1, 2}, []int{1, 1}, false},
+		{nil, []int{}, true},
+	}
+	for _, test := range tests {
+		if sameIDs(test.a, test.b) != test.same {
+			t.Errorf("%v and %v: same %v", test.a, test.b, !test.same)
+		}
+	}
+}
+
+func TestResolvePropertiesDuplicateTerms(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-WP-TotalPages", "1")
+		w.Write([]byte(`[{"id":7,"slug":"kubernetes"}]`))
+	}))
+	defer server.Close()
+	client := wordepress.NewClient(server.URL, nil)
+
+	// Both name the same tag
+	document := &wordepress.Document{Slug: "install", TagNames: []string{"Kubernetes", "kubernetes"}}
+	if err := resolveProperties(context.Background(), client, []*wordepress.Document{document}); err != nil {
+		t.Fatalf("resolveProperties: %v", err)
+	}
+	if len(document.Tags) != 1 || document.Tags[0] != 7 {
+		t.Errorf("tags %v, want [7]", document.Tags)
+	}
+}
+
+func TestCheckFields(t *testing.T) {
+	link := &wordepress.Document{Slug: "github", Link: "https://github.com/weaveworks"}
+	page := &wordepress.Document{Slug: "install"}
+
+	tests := []struct {
+		restBase  string
+		fields    wordepress.FieldNames
+		documents []*wordepress.Document
+		ok        bool
+	}{
+		{"documentation", wordepress.DefaultFieldNames, []*wordepress.Document{page, link}, true},
+		// Not registered for the post type
+		{"pages", wordepress.DefaultFieldNames, []*wordepress.Document{page}, false},
+		{"pages", wordepress.FieldNames{Link: "wordepress-link"}, []*wordepress.Document{page, link}, false},
+		{"pages", wordepress.FieldNames{Link: "wordepress-link"}, []*wordepress.Document{page}, true},
+		{"pages", wordepress.FieldNames{Link: "meta.link"}, []*wordepress.Document{link}, true},
+		{"pages", wordepress.FieldNames{Link: "meta.target"}, []*wordepress.Document{link}, false},
+		{"pages", wordepress.FieldNames{}, []*wordepress.Document{link}, true},
+	}
+	for i, test := range tests {
+		client, server := newTestClient(t)
+		server.Meta = []string{"link"}
+		client.RestBase = test.restBase
+		client.Fields = test.fields
+		if err := checkFields(context.Background(), client, test.documents); (err == nil) != test.ok {
+			t.Errorf("%d: got %v", i, err)
+		}
+		if posts := server.Posts(test.restBase); len(posts) != 0 {
+			t.Errorf("%d: wrote %d posts", i, len(posts))
+		}
+	}
+}
+
+// runCommand runs wordepress with the given arguments against a fake
+// WordPress, restoring the flags it sets afterwards. Errors exit the test
+// binary, as they do wordepress.
+func runCommand(t *testing.T, server *wordepresstest.Server, args ...string) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("NETRC", filepath.Join(t.TempDir(), "netrc"))
+	sources = make(map[string]string)
+	profile, configPath = "", ""
+
+	args = append(args, "--url", server.URL, "--user", "admin", "--password", "secret", "--retries", "0")
+	command, _, err := RootCmd.Find(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, flags := range []*pflag.FlagSet{RootCmd.PersistentFlags(), command.Flags()} {
+			flags.VisitAll(func(flag *pflag.Flag) {
+				if !flag.Changed {
+					return
+				}
+				if slice, ok := flag.Value.(pflag.SliceValue); ok {
+					var values []string
+					if defaults := strings.Trim(flag.DefValue, "[]"); defaults != "" {
+						values = strings.Split(defaults, ",")
+					}
+					slice.Replace(values)
+				} else {
+					flag.Value.Set(flag.DefValue)
+				}
+				flag.Changed = false
+			})
+		}
+	}()
+
+	RootCmd.SetArgs(args)
+	if err := RootCmd.Execute(); err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+}
+
+func TestPublishAndDelete(t *testing.T) {
+	server := wordepresstest.NewServer()
+	t.Cleanup(server.Close)
+
+	site := t.TempDir()
+	for name, content := range map[string]string{
+		"index.md":    "---\ntitle: Weave Net\n---\nSee [installing](/site/install.md).\n",
+		"install.md":  "---\ntitle: Installing\n---\n![Diagram](diagram.svg)\n",
+		"diagram.svg": `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"></svg>`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(site, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish := func() {
+		runCommand(t, server, "publish", "--product", "net", "--tag", "latest", "--version", "2.8", site)
+	}
+
+	publish()
+	documents := server.Posts("documentation")
+	if len(documents) != 2 || len(server.Posts("media")) != 1 {
+		t.Fatalf("published %d documents and %d images, want 2 and 1", len(documents), len(server.Posts("media")))
+	}
+	modified := make(map[string]interface{})
+	for _, document := range documents {
+		modified[document["slug"].(string)] = document["modified_gmt"]
+		if document["wordepress-checksum"] != document["modified_gmt"] {
+			t.Errorf("%s: checksum %v, want when it was saved", document["slug"], document["wordepress-checksum"])
+		}
+	}
+	content := documents[1]["content"].(map[string]interface{})["raw"].(string)
+	if !strings.Contains(content, server.URL+"/wp-content/uploads/") {
+		t.Errorf("install content %s doesn't reference the upload", content)
+	}
+
+	// Publishing again changes nothing
+	publish()
+	for _, document := range server.Posts("documentation") {
+		if document["modified_gmt"] != modified[document["slug"].(string)] {
+			t.Errorf("%s updated by publishing it unchanged", document["slug"])
+		}
+	}
+	if len(server.Posts("media")) != 1 {
+		t.Errorf("image uploaded again")
+	}
+
+	// Documents removed from the site are deleted
+	if err := os.Remove(filepath.Join(site, "install.md")); err != nil {
+		t.Fatal(err)
+	}
+	publish()
+	if documents := server.Posts("documentation"); len(documents) != 1 || documents[0]["slug"] != "net-latest-index" {
+		t.Errorf("%d documents left, want just the index", len(documents))
+	}
+
+	runCommand(t, server, "delete", "--product", "net", "--tag", "latest")
+	if documents := server.Posts("documentation"); len(documents) != 0 {
+		t.Errorf("%d documents left after delete", len(documents))
+	}
+}
@@ -2,24 +2,171 @@ package cmd
 
 import (
 	"fmt"
+	"log"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/weaveworks/wordepress"
+	"golang.org/x/time/rate"
 )
 
 var (
-	dryRun   bool
-	baseURL  string
-	user     string
-	password string
-	product  string
-	tag      string
+	dryRun      bool
+	baseURL     string
+	user        string
+	password    string
+	product     string
+	tag         string
+	timeout     time.Duration
+	retries     int
+	rateLimit   float64
+	concurrency int
+	authMethod  string
+	token       string
+	tokenURL    string
+	headers     []string
+	postType    string
+	restBase    string
+	fieldNames  wordepress.FieldNames
+	metaFields  []string
+	batch       bool
 )
 
 var RootCmd = &cobra.Command{
 	Use:   "wordepress",
 	Short: "Technical documentation importer for WordPress",
 	Long:  `Technical documentation importer for WordPress`,
+	// Execute reports errors itself
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadConfig(cmd); err != nil {
+			// Usage is no help with a broken configuration
+			cmd.SilenceUsage = true
+			return err
+		}
+		return nil
+	},
+}
+
+// authConfigured reports whether sufficient credentials have been supplied
+// for the selected authentication method
+func authConfigured() bool {
+	switch authMethod {
+	case "bearer":
+		return token != ""
+	case "basic", "jwt", "cookie":
+		return user != "" && password != ""
+	}
+	return false
+}
+
+func newAuthenticator(httpClient *http.Client) (wordepress.Authenticator, error) {
+	var auth wordepress.Authenticator
+	switch authMethod {
+	case "basic":
+		auth = &wordepress.BasicAuth{User: user, Password: password}
+	case "bearer":
+		auth = &wordepress.BearerAuth{Token: token}
+	case "jwt":
+		url := tokenURL
+		if url == "" {
+			url = strings.TrimSuffix(baseURL, "/") + "/wp-json/jwt-auth/v1/token"
+		}
+		auth = &wordepress.BearerAuth{
+			TokenURL:   url,
+			User:       user,
+			Password:   password,
+			HTTPClient: httpClient}
+	case "cookie":
+		auth = &wordepress.CookieAuth{
+			BaseURL:    baseURL,
+			User:       user,
+			Password:   password,
+			HTTPClient: httpClient}
+	default:
+		return nil, fmt.Errorf("unknown authentication method: %s", authMethod)
+	}
+
+	if len(headers) == 0 {
+		return auth, nil
+	}
+
+	multi := wordepress.MultiAuth{auth}
+	for _, header := range headers {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf(`invalid header "%s": expected "Name: value"`, header)
+		}
+		name := strings.TrimSpace(parts[0])
+		// Would silently replace the credentials of every method but cookie
+		if strings.EqualFold(name, "Authorization") && authMethod != "cookie" {
+			return nil, fmt.Errorf("header %s conflicts with %s authentication: use --auth bearer with --token instead", name, authMethod)
+		}
+		multi = append(multi, &wordepress.HeaderAuth{
+			Name:  name,
+			Value: strings.TrimSpace(parts[1])})
+	}
+	return multi, nil
+}
+
+// defaultRestBase returns the REST base WordPress assigns to a post type by
+// default
+func defaultRestBase(postType string) string {
+	switch postType {
+	case "post":
+		return "posts"
+	case "page":
+		return "pages"
+	case "attachment":
+		return "media"
+	}
+	return postType
+}
+
+func newClient() *wordepress.Client {
+	client := wordepress.NewClient(baseURL, nil)
+	client.HTTPClient.Timeout = timeout
+
+	client.RestBase = restBase
+	if client.RestBase == "" {
+		client.RestBase = defaultRestBase(postType)
+	}
+	client.Fields = fieldNames
+	for _, declaration := range metaFields {
+		field, err := wordepress.ParseMetaField(declaration)
+		if err != nil {
+			log.Fatalf("Error configuring fields: %v", err)
+		}
+		client.Fields.Meta = append(client.Fields.Meta, field)
+	}
+	if err := client.Fields.Validate(); err != nil {
+		log.Fatalf("Error configuring fields: %v", err)
+	}
+
+	auth, err := newAuthenticator(client.HTTPClient)
+	if err != nil {
+		log.Fatalf("Error configuring authentication: %v", err)
+	}
+	client.Auth = auth
+
+	client.Retry.MaxAttempts = retries + 1
+	if rateLimit > 0 {
+		client.Limiter = rate.NewLimiter(rate.Limit(rateLimit), 1)
+	}
+	return client
+}
+
+// fatal logs err along with any remedy suggested by WordPress' response and
+// exits
+func fatal(message string, err error) {
+	log.Printf("%s: %v", message, err)
+	if hint := wordepress.ErrorHint(err); hint != "" {
+		log.Printf("Hint: %s", hint)
+	}
+	os.Exit(1)
 }
 
 func Execute() {
@@ -36,4 +183,22 @@ func init() {
 	RootCmd.PersistentFlags().StringVarP(&password, "password", "", "", "Password for WordPress authentication")
 	RootCmd.PersistentFlags().StringVarP(&product, "product", "", "", "Value for document product field")
 	RootCmd.PersistentFlags().StringVarP(&tag, "tag", "", "", "Value for document tag field")
+	RootCmd.PersistentFlags().DurationVarP(&timeout, "timeout", "", wordepress.DefaultTimeout, "Timeout for each WordPress request")
+	RootCmd.PersistentFlags().IntVarP(&retries, "retries", "", wordepress.DefaultRetryPolicy.MaxAttempts-1, "Number of times to retry a failed WordPress request")
+	RootCmd.PersistentFlags().Float64VarP(&rateLimit, "rate-limit", "", 0, "Maximum WordPress requests per second (0 for unlimited)")
+	RootCmd.PersistentFlags().IntVarP(&concurrency, "concurrency", "", 4, "Maximum number of concurrent WordPress operations")
+	RootCmd.PersistentFlags().StringVarP(&authMethod, "auth", "", "basic", "Authentication method: basic, bearer, jwt or cookie")
+	RootCmd.PersistentFlags().StringVarP(&token, "token", "", "", "Token for bearer authentication")
+	RootCmd.PersistentFlags().StringVarP(&tokenURL, "token-url", "", "", "Token endpoint for jwt authentication (default $URL/wp-json/jwt-auth/v1/token)")
+	RootCmd.PersistentFlags().BoolVarP(&batch, "batch", "", true, "Combine document changes into batch requests where WordPress supports it")
+	RootCmd.PersistentFlags().StringVarP(&postType, "post-type", "", "documentation", "WordPress post type of published documents")
+	RootCmd.PersistentFlags().StringVarP(&restBase, "rest-base", "", "", "REST base of the post type (default derived from --post-type)")
+	RootCmd.PersistentFlags().StringVarP(&fieldNames.Product, "product-field", "", wordepress.DefaultFieldNames.Product, `Field holding the document product ("meta.<key>" for REST meta)`)
+	RootCmd.PersistentFlags().StringVarP(&fieldNames.Version, "version-field", "", wordepress.DefaultFieldNames.Version, "Field holding the document version (empty to omit)")
+	RootCmd.PersistentFlags().StringVarP(&fieldNames.Name, "name-field", "", wordepress.DefaultFieldNames.Name, "Field holding the document name (empty to omit)")
+	RootCmd.PersistentFlags().StringVarP(&fieldNames.Tag, "tag-field", "", wordepress.DefaultFieldNames.Tag, "Field holding the document tag")
+	RootCmd.PersistentFlags().StringVarP(&fieldNames.Checksum, "checksum-field", "", wordepress.DefaultFieldNames.Checksum, "Field recording what was last published, to detect edits made in WordPress (empty to disable)")
+	RootCmd.PersistentFlags().StringVarP(&fieldNames.Link, "link-field", "", wordepress.DefaultFieldNames.Link, "Field holding the URL of pages standing in for links in a site's navigation (empty to omit)")
+	RootCmd.PersistentFlags().StringArrayVarP(&metaFields, "meta-field", "", nil, `Custom front matter field as "key[=field][:type]", the type being string, number, bool or list (default "meta.<key>" and string; repeatable)`)
+	RootCmd.PersistentFlags().StringArrayVarP(&headers, "header", "", nil, `Extra "Name: value" header sent with every request (repeatable)`)
 }
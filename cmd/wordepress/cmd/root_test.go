@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAuthorizationHeader(t *testing.T) {
+	defer func(m, u, p string, h []string) { authMethod, user, password, headers = m, u, p, h }(authMethod, user, password, headers)
+	user, password = "alice", "s3cret"
+
+	tests := []struct {
+		method, header string
+		ok             bool
+	}{
+		{"basic", "X-Proxy-Token: abc", true},
+		{"basic", "Authorization: Bearer abc", false},
+		{"jwt", "authorization: Bearer abc", false},
+		// Cookie authentication leaves the header to a proxy
+		{"cookie", "Authorization: Bearer abc", true},
+	}
+	for _, test := range tests {
+		authMethod, headers = test.method, []string{test.header}
+		_, err := newAuthenticator(http.DefaultClient)
+		if (err == nil) != test.ok {
+			t.Errorf("%s with %q: got %v", test.method, test.header, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/weaveworks/wordepress"
+)
+
+// task is a unit of remote work that may only start once all of the tasks it
+// depends upon have completed successfully
+type task struct {
+	run  func(ctx context.Context) error
+	deps []*task
+	done chan struct{}
+}
+
+func newTask(run func(ctx context.Context) error) *task {
+	return &task{run: run, done: make(chan struct{})}
+}
+
+func (t *task) after(deps ...*task) {
+	t.deps = append(t.deps, deps...)
+}
+
+// runTasks executes tasks with at most concurrency running at once, honouring
+// their dependencies. The first error cancels all outstanding work and is
+// returned once every started task has finished.
+func runTasks(ctx context.Context, concurrency int, tasks []*task) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg        sync.WaitGroup
+		once      sync.Once
+		firstErr  error
+		semaphore = make(chan struct{}, concurrency)
+	)
+
+	for _, t := range tasks {
+		wg.Add(1)
+		go func(t *task) {
+			defer wg.Done()
+			defer close(t.done)
+
+			for _, dep := range t.deps {
+				select {
+				case <-dep.done:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case semaphore <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-semaphore }()
+
+			// A failed dependency cancels the context before closing its done
+			// channel, so this catches dependents that raced past the select
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err := t.run(ctx); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(t)
+	}
+
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// deleteTasks returns tasks deleting the given remote documents, ordered such
+// that children are removed before their parents
+func deleteTasks(client *wordepress.Client, documents []*wordepress.Document) []*task {
+	byID := make(map[int]*task)
+	var tasks []*task
+	for _, document := range documents {
+		if document.Product != product || document.Tag != tag {
+			// meta_query filter was ignored, most likely due to wrong plugin version
+			log.Printf("Skipping delete of %s due to product/tag mismatch. "+
+				"Is your plugin up to date?", document.Slug)
+			continue
+		}
+
+		document := document
+		t := newTask(func(ctx context.Context) error {
+			if dryRun {
+				log.Printf("Would delete document: %s", document.Slug)
+				return nil
+			}
+			log.Printf("Deleting document: %s", document.Slug)
+			if err := client.DeleteDocument(ctx, document); err != nil {
+				return fmt.Errorf("%s: %w", document.Slug, err)
+			}
+			return nil
+		})
+		byID[document.ID] = t
+		tasks = append(tasks, t)
+	}
+
+	for _, document := range documents {
+		child, ok := byID[document.ID]
+		if !ok {
+			continue
+		}
+		if parent, ok := byID[document.Parent]; ok && document.Parent != 0 {
+			parent.after(child)
+		}
+	}
+
+	return tasks
+}
+
+// deleteDocuments deletes documents in batches of size, or individually if
+// size is zero
+func deleteDocuments(ctx context.Context, client *wordepress.Client, size int, documents []*wordepress.Document) error {
+	if size > 0 {
+		return deleteBatched(ctx, client, size, documents)
+	}
+	return runTasks(ctx, concurrency, deleteTasks(client, documents))
+}
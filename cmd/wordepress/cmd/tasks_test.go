@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunTasksDependencies(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	record := func(name string) *task {
+		return newTask(func(ctx context.Context) error {
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil
+		})
+	}
+	// A diamond: d after b and c, both after a
+	a, b, c, d := record("a"), record("b"), record("c"), record("d")
+	b.after(a)
+	c.after(a)
+	d.after(b, c)
+
+	if err := runTasks(context.Background(), 4, []*task{d, c, b, a}); err != nil {
+		t.Fatal(err)
+	}
+	position := make(map[string]int)
+	for i, name := range order {
+		position[name] = i
+	}
+	if len(order) != 4 || position["a"] != 0 || position["d"] != 3 {
+		t.Errorf("ran in order %v", order)
+	}
+}
+
+func TestRunTasksConcurrency(t *testing.T) {
+	var running, peak int32
+	var tasks []*task
+	for i := 0; i < 10; i++ {
+		tasks = append(tasks, newTask(func(ctx context.Context) error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil
+		}))
+	}
+
+	if err := runTasks(context.Background(), 3, tasks); err != nil {
+		t.Fatal(err)
+	}
+	if peak > 3 || peak < 1 {
+		t.Errorf("%d tasks ran at once, want at most 3", peak)
+	}
+
+	// Concurrency below one runs tasks one at a time rather than not at all
+	var ran int32
+	one := newTask(func(ctx context.Context) error { atomic.AddInt32(&ran, 1); return nil })
+	if err := runTasks(context.Background(), 0, []*task{one}); err != nil || ran != 1 {
+		t.Errorf("concurrency 0: ran %d, %v", ran, err)
+	}
+}
+
+func TestRunTasksError(t *testing.T) {
+	failure := errors.New("failed")
+	var dependentRan, cancelled int32
+
+	started := make(chan struct{})
+	failing := newTask(func(ctx context.Context) error {
+		<-started
+		return failure
+	})
+	dependent := newTask(func(ctx context.Context) error {
+		atomic.AddInt32(&dependentRan, 1)
+		return nil
+	})
+	dependent.after(failing)
+	slow := newTask(func(ctx context.Context) error {
+		close(started)
+		select {
+		case <-ctx.Done():
+			atomic.AddInt32(&cancelled, 1)
+			return ctx.Err()
+		case <-time.After(10 * time.Second):
+			return nil
+		}
+	})
+	later := newTask(func(ctx context.Context) error { return errors.New("second failure") })
+	later.after(slow)
+
+	err := runTasks(context.Background(), 4, []*task{slow, failing, dependent, later})
+	if err != failure {
+		t.Fatalf("got %v, want the first failure", err)
+	}
+	if dependentRan != 0 {
+		t.Error("a task ran after its dependency failed")
+	}
+	if cancelled != 1 {
+		t.Error("a running task wasn't cancelled")
+	}
+}
+
+func TestRunTasksCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var ran int32
+	t1 := newTask(func(ctx context.Context) error { atomic.AddInt32(&ran, 1); return nil })
+	if err := runTasks(ctx, 1, []*task{t1}); err != context.Canceled || ran != 0 {
+		t.Errorf("got %v with %d run, want context.Canceled and none", err, ran)
+	}
+}
@@ -0,0 +1,73 @@
+package wordepress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// RecordModified is sent in the checksum field of a document being published.
+// The Wordepress plugin records in its place the time at which WordPress saved
+// the document, so that the document has since been modified by someone else
+// if its modified_gmt no longer matches.
+const RecordModified = "modified"
+
+// Drifted reports whether a remote document has been modified since it was
+// last published. Documents without a recorded time (e.g. published by an
+// older wordepress) are assumed not to have drifted.
+func (d *Document) Drifted() bool {
+	return d.Recorded != "" && d.Recorded != RecordModified && d.Recorded != d.Modified
+}
+
+// Unmodified reports whether a remote document records that it is as last
+// published, rather than needing an update to record so
+func (d *Document) Unmodified() bool {
+	return d.Recorded != "" && d.Recorded == d.Modified
+}
+
+// LastEditor returns the display name of the author of the most recent
+// revision of a document, or the empty string if that can't be determined
+// because the post type doesn't support revisions
+func (c *Client) LastEditor(ctx context.Context, ID int) (string, error) {
+	url := fmt.Sprintf("%s/%d/revisions?context=edit&per_page=1&_fields=author", c.DocumentsEndpoint(), ID)
+	var revisions []struct {
+		Author int `json:"author"`
+	}
+	if ok, err := c.getJSON(ctx, url, &revisions); !ok || len(revisions) == 0 {
+		return "", err
+	}
+
+	url = c.BaseURL + "/wp-json/wp/v2/users/" + strconv.Itoa(revisions[0].Author) + "?_fields=name"
+	var author struct {
+		Name string `json:"name"`
+	}
+	if ok, err := c.getJSON(ctx, url, &author); !ok {
+		return "", err
+	}
+	return author.Name, nil
+}
+
+// getJSON fetches url into value, returning false without error if it doesn't
+// exist
+func (c *Client) getJSON(ctx context.Context, url string, value interface{}) (bool, error) {
+	request, err := c.newRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+
+	response, responseBytes, err := c.do(request, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return false, newAPIError(response, responseBytes)
+	}
+
+	return true, json.Unmarshal(responseBytes, value)
+}
@@ -0,0 +1,53 @@
+package wordepress
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDrifted(t *testing.T) {
+	client, server := newTestClient(t)
+	ctx := context.Background()
+	query := "context=edit&per_page=100&status=any"
+	fetch := func() *Document {
+		documents, err := client.GetDocuments(ctx, query)
+		if err != nil || len(documents) != 1 {
+			t.Fatalf("GetDocuments: %v, %v", documents, err)
+		}
+		return documents[0]
+	}
+
+	document := newTestDocument("install")
+	document.Recorded = RecordModified
+	posted, err := client.PostDocument(ctx, document)
+	if err != nil {
+		t.Fatalf("PostDocument: %v", err)
+	}
+	if posted.Recorded != posted.Modified || !posted.Unmodified() || posted.Drifted() {
+		t.Errorf("just published: recorded %q, modified %q", posted.Recorded, posted.Modified)
+	}
+
+	// Any edit counts, not just to the content
+	server.EditPost(DefaultRestBase, posted.ID, 2, map[string]interface{}{"excerpt": "Edited"})
+	if remote := fetch(); !remote.Drifted() || remote.Unmodified() {
+		t.Errorf("edited: recorded %q, modified %q", remote.Recorded, remote.Modified)
+	}
+
+	if _, err := client.PutDocument(ctx, posted.ID, document); err != nil {
+		t.Fatalf("PutDocument: %v", err)
+	}
+	if remote := fetch(); remote.Drifted() || !remote.Unmodified() {
+		t.Errorf("republished: recorded %q, modified %q", remote.Recorded, remote.Modified)
+	}
+}
+
+func TestDriftedUnrecorded(t *testing.T) {
+	// Published by an earlier wordepress, or by a plugin that stored what was
+	// sent rather than recording the time
+	for _, recorded := range []string{"", RecordModified} {
+		document := &Document{Recorded: recorded, Modified: "2021-03-04T09:00:00"}
+		if document.Drifted() || document.Unmodified() {
+			t.Errorf("%q: drifted %v, unmodified %v", recorded, document.Drifted(), document.Unmodified())
+		}
+	}
+}
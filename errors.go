@@ -0,0 +1,125 @@
+package wordepress
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// APIError is a failed WordPress REST API response, decoded from the standard
+// WP_Error JSON envelope where possible
+type APIError struct {
+	// Status is the HTTP status line and StatusCode its numeric code
+	Status     string `json:"-"`
+	StatusCode int    `json:"-"`
+
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		Status int                    `json:"status"`
+		Params map[string]interface{} `json:"params"`
+
+		// TermID identifies the existing term on a term_exists error
+		TermID int `json:"term_id"`
+	} `json:"data"`
+
+	// Body holds the raw response when it wasn't a WordPress error envelope,
+	// for example an HTML error page from a proxy
+	Body string `json:"-"`
+}
+
+func newAPIError(response *http.Response, responseBytes []byte) *APIError {
+	return apiErrorFor(response.StatusCode, response.Status, responseBytes)
+}
+
+func apiErrorFor(statusCode int, status string, responseBytes []byte) *APIError {
+	apiError := &APIError{}
+	if err := json.Unmarshal(responseBytes, apiError); err != nil || apiError.Code == "" {
+		apiError = &APIError{Body: strings.TrimSpace(string(responseBytes))}
+	}
+	apiError.Status = status
+	apiError.StatusCode = statusCode
+	return apiError
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		if e.Body == "" {
+			return e.Status
+		}
+		return fmt.Sprintf("%s: %s", e.Status, e.Body)
+	}
+
+	message := fmt.Sprintf("%s: %s (%s)", e.Status, e.Message, e.Code)
+	if len(e.Data.Params) > 0 {
+		var params []string
+		for param, reason := range e.Data.Params {
+			params = append(params, fmt.Sprintf("%s: %v", param, reason))
+		}
+		sort.Strings(params)
+		message += " [" + strings.Join(params, "; ") + "]"
+	}
+	return message
+}
+
+// Hint suggests a likely remedy for the error, or returns the empty string if
+// there is nothing more useful to say than the error itself
+func (e *APIError) Hint() string {
+	switch e.Code {
+	case "incorrect_password", "invalid_username", "invalid_email":
+		return "application password rejected - check the username is the " +
+			"WordPress login rather than the application password name"
+	case "rest_not_logged_in":
+		return "request was not authenticated - check the credentials and --auth " +
+			"method, and that the corresponding plugin is active"
+	case "rest_cannot_create", "rest_cannot_edit", "rest_cannot_delete", "rest_forbidden_context":
+		return "the WordPress user lacks permission to manage documentation"
+	case "rest_invalid_param":
+		return "WordPress rejected a field value - check the field names given " +
+			"match those registered for the post type"
+	case "rest_no_route":
+		return "REST route not found - is the Wordepress plugin installed and activated?"
+	case "rest_post_invalid_id":
+		return "the document no longer exists - was it deleted concurrently?"
+	case "rest_post_invalid_page_number":
+		return "the document listing changed while it was being paged through"
+	case "rest_upload_unknown_error", "rest_upload_sideload_error", "rest_upload_file_too_big":
+		return "WordPress could not store the upload - check the uploads directory " +
+			"is writable and the file type and size are permitted"
+	case "rest_upload_no_content_type", "rest_upload_no_content_disposition":
+		return "upload was rejected by a proxy stripping request headers"
+	}
+
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return "authentication failed - check --user and --password"
+	case http.StatusForbidden:
+		return "request was refused - check the WordPress user's role, and that " +
+			"no security plugin or firewall blocks the REST API"
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return "WordPress is overloaded - try a lower --rate-limit or --concurrency"
+	}
+	return ""
+}
+
+// ErrorCode returns the WordPress error code carried by err, or the empty
+// string if err is not an *APIError
+func ErrorCode(err error) string {
+	var apiError *APIError
+	if errors.As(err, &apiError) {
+		return apiError.Code
+	}
+	return ""
+}
+
+// ErrorHint returns the remedy suggested by an *APIError carried by err
+func ErrorHint(err error) string {
+	var apiError *APIError
+	if errors.As(err, &apiError) {
+		return apiError.Hint()
+	}
+	return ""
+}
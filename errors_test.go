@@ -0,0 +1,61 @@
+package wordepress
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		code string
+		want string
+	}{
+		{"wordpress error",
+			`{"code":"rest_invalid_param","message":"Invalid parameter(s): status","data":{"status":400,"params":{"status":"status is not one of publish, draft."}}}`,
+			"rest_invalid_param",
+			"400 Bad Request: Invalid parameter(s): status (rest_invalid_param) [status: status is not one of publish, draft.]"},
+		{"proxy page", "<html>Bad Gateway</html>\n", "", "400 Bad Request: <html>Bad Gateway</html>"},
+		{"json without a code", `{"error":"nope"}`, "", `400 Bad Request: {"error":"nope"}`},
+		{"empty", "", "", "400 Bad Request"},
+	}
+	for _, test := range tests {
+		response := &http.Response{StatusCode: http.StatusBadRequest, Status: "400 Bad Request"}
+		err := newAPIError(response, []byte(test.body))
+		if err.StatusCode != http.StatusBadRequest || err.Code != test.code {
+			t.Errorf("%s: status %d and code %q", test.name, err.StatusCode, err.Code)
+		}
+		if err.Error() != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, err.Error(), test.want)
+		}
+	}
+}
+
+func TestErrorHint(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		body       string
+		want       string
+	}{
+		{http.StatusForbidden, `{"code":"rest_cannot_create","message":"Sorry, you are not allowed to create posts as this user."}`, "lacks permission"},
+		{http.StatusBadRequest, `{"code":"rest_invalid_param","message":"Invalid parameter(s): meta"}`, "field names"},
+		{http.StatusUnauthorized, `{"code":"incorrect_password","message":"The provided password is an invalid application password."}`, "application password"},
+		{http.StatusUnauthorized, "Unauthorized", "check --user and --password"},
+		{http.StatusForbidden, "<html>Forbidden</html>", "firewall"},
+		{http.StatusBadRequest, "", ""},
+	}
+	for _, test := range tests {
+		apiError := apiErrorFor(test.statusCode, http.StatusText(test.statusCode), []byte(test.body))
+		// Found however deeply wrapped
+		hint := ErrorHint(fmt.Errorf("publishing index: %w", apiError))
+		if (test.want == "") != (hint == "") || !strings.Contains(hint, test.want) {
+			t.Errorf("%d %s: hint %q, want %q", test.statusCode, test.body, hint, test.want)
+		}
+	}
+	if ErrorHint(fmt.Errorf("not from WordPress")) != "" {
+		t.Errorf("hint for an error not from WordPress")
+	}
+}
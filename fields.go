@@ -0,0 +1,237 @@
+package wordepress
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const metaPrefix = "meta."
+
+// FieldNames names the WordPress fields holding the wordepress specific
+// properties of a Document. A name of the form "meta.key" addresses the key
+// within the REST meta object (as registered with register_post_meta);
+// anything else is a top level field such as those registered by the
+// Wordepress plugin. Version and Name may be left empty to omit them,
+// Checksum to disable detection of documents modified in WordPress and Link
+// to publish links in the navigation as ordinary pages. Meta declares further
+// fields given in front matter.
+type FieldNames struct {
+	Product  string
+	Version  string
+	Name     string
+	Tag      string
+	Checksum string
+	Link     string
+	Meta     []MetaField
+}
+
+// DefaultFieldNames are the fields of the Toolset documentation post type
+var DefaultFieldNames = FieldNames{
+	Product:  "wpcf-product",
+	Version:  "wpcf-version",
+	Name:     "wpcf-name",
+	Tag:      "wpcf-tag",
+	Checksum: "wordepress-checksum",
+	Link:     "wordepress-link"}
+
+func (f FieldNames) Validate() error {
+	if f.Product == "" || f.Tag == "" {
+		return fmt.Errorf("product and tag field names are required")
+	}
+	if strings.HasPrefix(f.Checksum, metaPrefix) {
+		return fmt.Errorf("checksum field %s must be registered by the Wordepress plugin, which records when documents are saved", f.Checksum)
+	}
+
+	builtin := frontMatterFields("yaml")
+	used := make(map[string]bool)
+	for name := range f.values(&Document{}) {
+		used[name] = true
+	}
+	for _, name := range documentFields() {
+		used[strings.TrimSuffix(name, ".raw")] = true
+	}
+	keys := make(map[string]bool)
+	for _, field := range f.Meta {
+		if _, ok := builtin[field.Key]; ok || keys[field.Key] {
+			return fmt.Errorf("custom field %s is already defined", field.Key)
+		}
+		if used[field.Field] || field.Field == "meta" {
+			return fmt.Errorf("custom field %s: WordPress field %s is already in use", field.Key, field.Field)
+		}
+		keys[field.Key] = true
+		used[field.Field] = true
+	}
+	return nil
+}
+
+// MetaKey returns the post meta key underlying a field name, as used in
+// meta_query filters
+func MetaKey(name string) string {
+	return strings.TrimPrefix(name, metaPrefix)
+}
+
+// values pairs each configured field name with the Document property it holds
+func (f FieldNames) values(document *Document) map[string]*string {
+	values := make(map[string]*string)
+	for name, value := range map[string]*string{
+		f.Product:  &document.Product,
+		f.Version:  &document.Version,
+		f.Name:     &document.Name,
+		f.Tag:      &document.Tag,
+		f.Checksum: &document.Recorded,
+		f.Link:     &document.Link} {
+		if name != "" {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// ProductQuery returns the filter selecting the documents of a product and tag
+// (requires the WP REST API filter parameter plugin)
+func (c *Client) ProductQuery(product, tag string) string {
+	return fmt.Sprintf(
+		"filter[meta_query][0][key]=%s&"+
+			"filter[meta_query][0][value]=%s&"+
+			"filter[meta_query][1][key]=%s&"+
+			"filter[meta_query][1][value]=%s",
+		MetaKey(c.Fields.Product), product, MetaKey(c.Fields.Tag), tag)
+}
+
+// DocumentFields returns the _fields projection matching Document: its JSON
+// fields and configured meta fields, with text fields narrowed to their raw
+// values
+func (c *Client) DocumentFields() []string {
+	fields := documentFields()
+	for name := range c.Fields.values(&Document{}) {
+		fields = append(fields, name)
+	}
+	for _, field := range c.Fields.Meta {
+		fields = append(fields, field.Field)
+	}
+	return fields
+}
+
+// documentFields returns the JSON fields of Document, narrowing text fields to
+// their raw values
+func documentFields() []string {
+	var fields []string
+	documentType := reflect.TypeOf(Document{})
+	for i := 0; i < documentType.NumField(); i++ {
+		field := documentType.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		if field.Type == reflect.TypeOf(Text{}) {
+			name += ".raw"
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+func (c *Client) marshalDocument(document *Document) ([]byte, error) {
+	documentBytes, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	var object map[string]interface{}
+	err = json.Unmarshal(documentBytes, &object)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := make(map[string]interface{})
+	for name, value := range c.Fields.values(document) {
+		if strings.HasPrefix(name, metaPrefix) {
+			meta[MetaKey(name)] = *value
+		} else {
+			object[name] = *value
+		}
+	}
+	for _, field := range c.Fields.Meta {
+		value, ok := document.Meta[field.Key]
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(field.Field, metaPrefix) {
+			meta[MetaKey(field.Field)] = value
+		} else {
+			object[field.Field] = value
+		}
+	}
+	if len(meta) > 0 {
+		object["meta"] = meta
+	}
+
+	return json.Marshal(object)
+}
+
+func (c *Client) unmarshalDocument(documentBytes []byte, document *Document) error {
+	err := json.Unmarshal(documentBytes, document)
+	if err != nil {
+		return err
+	}
+
+	var object struct {
+		Fields map[string]json.RawMessage
+		Meta   map[string]json.RawMessage
+	}
+	err = json.Unmarshal(documentBytes, &object.Fields)
+	if err != nil {
+		return err
+	}
+	if meta, ok := object.Fields["meta"]; ok {
+		// An empty meta object is serialised by PHP as an empty array
+		json.Unmarshal(meta, &object.Meta)
+	}
+
+	for name, value := range c.Fields.values(document) {
+		raw := object.Fields[name]
+		if strings.HasPrefix(name, metaPrefix) {
+			raw = object.Meta[MetaKey(name)]
+		}
+		// Leave fields that aren't strings (e.g. absent, or multi-valued meta)
+		// empty rather than failing the whole document
+		json.Unmarshal(raw, value)
+	}
+
+	for _, field := range c.Fields.Meta {
+		raw := object.Fields[field.Field]
+		if strings.HasPrefix(field.Field, metaPrefix) {
+			raw = object.Meta[MetaKey(field.Field)]
+		}
+		if raw == nil {
+			continue
+		}
+		if value, err := field.Type.decode(raw); err == nil {
+			if document.Meta == nil {
+				document.Meta = make(map[string]interface{})
+			}
+			document.Meta[field.Key] = value
+		}
+	}
+	return nil
+}
+
+func (c *Client) unmarshalDocuments(documentsBytes []byte) ([]*Document, error) {
+	var objects []json.RawMessage
+	err := json.Unmarshal(documentsBytes, &objects)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([]*Document, len(objects))
+	for i, object := range objects {
+		documents[i] = &Document{}
+		err = c.unmarshalDocument(object, documents[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return documents, nil
+}
@@ -0,0 +1,395 @@
+package wordepress
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatter is the metadata at the head of a markdown file, given either as
+// YAML between "---" lines or as TOML between "+++" lines
+type FrontMatter struct {
+	Title string `yaml:"title" toml:"title"`
+
+	// MenuOrder, if not given, is derived from the file's name or position
+	MenuOrder int `yaml:"menu_order" toml:"menu_order"`
+
+	// Slug replaces the filename as the document's URL path segment
+	Slug string `yaml:"slug" toml:"slug"`
+
+	// Status is one of draft, pending, private or publish (the default)
+	Status  string `yaml:"status" toml:"status"`
+	Excerpt string `yaml:"excerpt" toml:"excerpt"`
+
+	// Author is the ID or slug (username) of a WordPress user
+	Author string `yaml:"author" toml:"author"`
+
+	// Date is the publication date, in UTC unless a time zone is given
+	Date time.Time `yaml:"date" toml:"date"`
+
+	// CommentStatus is open or closed
+	CommentStatus string `yaml:"comment_status" toml:"comment_status"`
+	Template      string `yaml:"template" toml:"template"`
+
+	// FeaturedImage is the path of an image relative to the markdown file
+	FeaturedImage string `yaml:"featured_image" toml:"featured_image"`
+
+	// Names of WordPress categories and tags, created if need be
+	Categories []string `yaml:"categories" toml:"categories"`
+	Tags       []string `yaml:"tags" toml:"tags"`
+
+	// Values of the custom fields given, by key
+	meta map[string]interface{}
+
+	// Where each field was given in the file
+	positions map[string]position
+}
+
+type position struct {
+	line, column int
+}
+
+// FrontMatterError locates a problem with the front matter of a file, in the
+// file:line:column form understood by editors
+type FrontMatterError struct {
+	Filename string
+	Line     int
+	Column   int
+	Message  string
+}
+
+func (e *FrontMatterError) Error() string {
+	switch {
+	case e.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", e.Filename, e.Line, e.Column, e.Message)
+	case e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.Filename, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Filename, e.Message)
+}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// Library errors only give the line, relative to the front matter
+var yamlLineRegexp = regexp.MustCompile(`^(?:yaml: )?line ([0-9]+): (.*)$`)
+var tomlLineRegexp = regexp.MustCompile(`^toml: line ([0-9]+) \(last key "([^".]*)[^)]*\): (.*)$`)
+
+// yaml.v3 numbers the lines of parser (as opposed to scanner) errors from 0
+var yamlParserErrorRegexp = regexp.MustCompile(`^(did not find expected (key|node content|'-' indicator|',' or '[\]}]'|<document start>|<stream-start>)|found (duplicate %|incompatible YAML|undefined tag handle))`)
+
+// parseFrontMatter splits the content of a markdown file into its front
+// matter and body. Keys other than the fields of FrontMatter must be declared
+// as custom fields.
+func parseFrontMatter(filename string, content []byte, custom []MetaField) (*FrontMatter, []byte, error) {
+	content = bytes.TrimPrefix(content, utf8BOM)
+	content = bytes.Replace(content, []byte("\r\n"), []byte("\n"), -1)
+	lines := strings.SplitAfter(string(content), "\n")
+
+	delimiter := strings.TrimRight(lines[0], " \t\n")
+	if delimiter != "---" && delimiter != "+++" {
+		return nil, nil, &FrontMatterError{filename, 1, 1, `missing front matter: expected "---" (YAML) or "+++" (TOML)`}
+	}
+
+	end := 1
+	for end < len(lines) && strings.TrimRight(lines[end], " \t\n") != delimiter {
+		end++
+	}
+	if end == len(lines) {
+		return nil, nil, &FrontMatterError{filename, 1, 1, fmt.Sprintf("front matter not closed by %q", delimiter)}
+	}
+
+	header := strings.Join(lines[1:end], "")
+	body := strings.Join(lines[end+1:], "")
+	if body != "" && !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+
+	declared := make(map[string]MetaField)
+	for _, field := range custom {
+		declared[field.Key] = field
+	}
+	frontMatter := &FrontMatter{meta: make(map[string]interface{}), positions: make(map[string]position)}
+	var err *FrontMatterError
+	if delimiter == "---" {
+		err = frontMatter.decodeYAML(header, declared)
+	} else {
+		err = frontMatter.decodeTOML(header, declared)
+	}
+	if err == nil {
+		err = frontMatter.validate()
+	}
+	if err != nil {
+		// Positions so far are relative to the line after the delimiter
+		err.Filename = filename
+		if err.Line > 0 {
+			err.Line++
+		}
+		return nil, nil, err
+	}
+
+	for field, p := range frontMatter.positions {
+		frontMatter.positions[field] = position{p.line + 1, p.column}
+	}
+	return frontMatter, []byte(body), nil
+}
+
+// frontMatterFields maps the keys of the given struct tag to the fields of
+// FrontMatter
+func frontMatterFields(tag string) map[string]int {
+	fields := make(map[string]int)
+	t := reflect.TypeOf(FrontMatter{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get(tag), ",")[0]; name != "" {
+			fields[name] = i
+		}
+	}
+	return fields
+}
+
+func (f *FrontMatter) decodeYAML(header string, custom map[string]MetaField) *FrontMatterError {
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(header), &root); err != nil {
+		return yamlError(err, 1)
+	}
+	if len(root.Content) == 0 {
+		return nil
+	}
+
+	mapping := root.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return &FrontMatterError{Line: mapping.Line, Column: mapping.Column, Message: "front matter must be a mapping of fields"}
+	}
+
+	fields := frontMatterFields("yaml")
+	value := reflect.ValueOf(f).Elem()
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key, node := mapping.Content[i], mapping.Content[i+1]
+		index, ok := fields[key.Value]
+		field, isCustom := custom[key.Value]
+		if !ok && !isCustom {
+			return &FrontMatterError{Line: key.Line, Column: key.Column, Message: fmt.Sprintf("unknown field %q", key.Value)}
+		}
+		if _, ok := f.positions[key.Value]; ok {
+			return &FrontMatterError{Line: key.Line, Column: key.Column, Message: fmt.Sprintf("duplicate field %q", key.Value)}
+		}
+		if isCustom {
+			value, ok := yamlMetaValue(field.Type, node)
+			if !ok {
+				return &FrontMatterError{Line: node.Line, Column: node.Column,
+					Message: fmt.Sprintf("%s: expected %s", key.Value, field.Type.description())}
+			}
+			f.meta[key.Value] = value
+		} else if err := node.Decode(value.Field(index).Addr().Interface()); err != nil {
+			e := yamlError(err, node.Line)
+			e.Column = node.Column
+			e.Message = fmt.Sprintf("%s: %s", key.Value, e.Message)
+			return e
+		}
+		f.positions[key.Value] = position{key.Line, key.Column}
+	}
+	return nil
+}
+
+// yamlMetaValue converts the value of a custom field. Strings are taken as
+// written, so that unquoted versions such as 1.10 aren't read as numbers.
+func yamlMetaValue(t MetaType, node *yaml.Node) (interface{}, bool) {
+	scalar := func(tags ...string) bool {
+		if node.Kind != yaml.ScalarNode {
+			return false
+		}
+		for _, tag := range tags {
+			if node.ShortTag() == tag {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch t {
+	case MetaNumber:
+		var value float64
+		return value, scalar("!!int", "!!float") && node.Decode(&value) == nil
+	case MetaBool:
+		var value bool
+		return value, scalar("!!bool") && node.Decode(&value) == nil
+	case MetaList:
+		if node.Kind != yaml.SequenceNode {
+			return nil, false
+		}
+		values := []string{}
+		for _, item := range node.Content {
+			value, ok := yamlMetaValue(MetaString, item)
+			if !ok {
+				return nil, false
+			}
+			values = append(values, value.(string))
+		}
+		return values, true
+	}
+	return node.Value, scalar("!!str", "!!int", "!!float", "!!timestamp")
+}
+
+// yamlError extracts the line from a YAML error, which for errors decoding a
+// node is relative to the node at line
+func yamlError(err error, line int) *FrontMatterError {
+	message := err.Error()
+	if typeErr, ok := err.(*yaml.TypeError); ok && len(typeErr.Errors) > 0 {
+		message = typeErr.Errors[0]
+	}
+	match := yamlLineRegexp.FindStringSubmatch(message)
+	if match == nil {
+		return &FrontMatterError{Line: line, Message: strings.TrimPrefix(message, "yaml: ")}
+	}
+	l, _ := strconv.Atoi(match[1])
+	if _, ok := err.(*yaml.TypeError); ok {
+		l = line
+	} else if yamlParserErrorRegexp.MatchString(match[2]) {
+		l++
+	}
+	return &FrontMatterError{Line: l, Message: match[2]}
+}
+
+func (f *FrontMatter) decodeTOML(header string, custom map[string]MetaField) *FrontMatterError {
+	metadata, err := toml.Decode(header, f)
+	if err != nil {
+		if parseErr, ok := err.(toml.ParseError); ok {
+			return &FrontMatterError{Line: parseErr.Position.Line, Column: parseErr.Position.Col, Message: parseErr.Message}
+		}
+		if match := tomlLineRegexp.FindStringSubmatch(err.Error()); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			return &FrontMatterError{Line: line, Column: tomlKeyPosition(header, match[2]).column,
+				Message: fmt.Sprintf("%s: %s", match[2], match[3])}
+		}
+		return &FrontMatterError{Message: strings.TrimPrefix(err.Error(), "toml: ")}
+	}
+
+	for _, key := range metadata.Keys() {
+		f.positions[key[0]] = tomlKeyPosition(header, key[0])
+	}
+
+	// Custom fields are left undecoded by the struct, so decode them afresh
+	var values map[string]interface{}
+	if len(custom) > 0 {
+		toml.Decode(header, &values)
+	}
+	for key, field := range custom {
+		raw, ok := values[key]
+		if !ok {
+			continue
+		}
+		value, ok := tomlMetaValue(field.Type, raw)
+		if !ok {
+			p := f.positions[key]
+			return &FrontMatterError{Line: p.line, Column: p.column, Message: fmt.Sprintf("%s: expected %s", key, field.Type.description())}
+		}
+		f.meta[key] = value
+	}
+
+	for _, key := range metadata.Undecoded() {
+		if _, ok := custom[key[0]]; ok {
+			continue
+		}
+		p := tomlKeyPosition(header, key[0])
+		return &FrontMatterError{Line: p.line, Column: p.column, Message: fmt.Sprintf("unknown field %q", key.String())}
+	}
+	return nil
+}
+
+// tomlMetaValue converts the value of a custom field
+func tomlMetaValue(t MetaType, raw interface{}) (interface{}, bool) {
+	switch t {
+	case MetaNumber:
+		switch value := raw.(type) {
+		case int64:
+			return float64(value), true
+		case float64:
+			return value, true
+		}
+		return nil, false
+	case MetaBool:
+		value, ok := raw.(bool)
+		return value, ok
+	case MetaList:
+		items, ok := raw.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		values := []string{}
+		for _, item := range items {
+			value, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			values = append(values, value)
+		}
+		return values, true
+	}
+	value, ok := raw.(string)
+	return value, ok
+}
+
+// tomlKeyPosition finds where a top-level key is defined, which the TOML
+// library doesn't expose
+func tomlKeyPosition(header, key string) position {
+	for i, line := range strings.Split(header, "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		name := strings.TrimLeft(trimmed, "[")
+		for _, quoted := range []string{key, `"` + key + `"`, `'` + key + `'`} {
+			if !strings.HasPrefix(name, quoted) {
+				continue
+			}
+			rest := strings.TrimLeft(name[len(quoted):], " \t")
+			if rest != "" && strings.ContainsRune("=.]", rune(rest[0])) {
+				return position{i + 1, len(line) - len(trimmed) + 1}
+			}
+		}
+	}
+	return position{}
+}
+
+func (f *FrontMatter) validate() *FrontMatterError {
+	// Folded and literal YAML strings end with a newline. A missing title
+	// may be given by the site's navigation instead.
+	f.Title = strings.TrimSpace(f.Title)
+
+	invalid := func(field, format string, args ...interface{}) *FrontMatterError {
+		p := f.positions[field]
+		return &FrontMatterError{Line: p.line, Column: p.column, Message: fmt.Sprintf(format, args...)}
+	}
+	switch f.Status {
+	case "", "draft", "pending", "private", "publish":
+	default:
+		return invalid("status", "invalid status %q: must be draft, pending, private or publish", f.Status)
+	}
+	switch f.CommentStatus {
+	case "", "open", "closed":
+	default:
+		return invalid("comment_status", "invalid comment_status %q: must be open or closed", f.CommentStatus)
+	}
+	for _, field := range []string{"categories", "tags"} {
+		names := f.Categories
+		if field == "tags" {
+			names = f.Tags
+		}
+		for _, name := range names {
+			if strings.TrimSpace(name) == "" {
+				return invalid(field, "empty name in %s", field)
+			}
+		}
+	}
+
+	// TOML dates without a time zone are local to the machine parsing them;
+	// take them as UTC for consistency with YAML
+	if strings.HasSuffix(f.Date.Location().String(), "-local") {
+		f.Date = time.Date(f.Date.Year(), f.Date.Month(), f.Date.Day(),
+			f.Date.Hour(), f.Date.Minute(), f.Date.Second(), f.Date.Nanosecond(), time.UTC)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package wordepress
+
+import "testing"
+
+func TestParseFrontMatterErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"missing", "# Title\n", `doc.md:1:1: missing front matter: expected "---" (YAML) or "+++" (TOML)`},
+		{"unclosed", "---\ntitle: Install\n\nSome text\n", `doc.md:1:1: front matter not closed by "---"`},
+		{"unclosed toml", "+++\ntitle = \"Install\"\n---\n", `doc.md:1:1: front matter not closed by "+++"`},
+		{"unknown field", "---\ntitle: Install\nauthors: [me]\n---\n", `doc.md:3:1: unknown field "authors"`},
+		{"duplicate key", "---\ntitle: Install\nslug: install\ntitle: Setup\n---\n", `doc.md:4:1: duplicate field "title"`},
+		{"type error", "---\ntitle: Install\nmenu_order: first\n---\n", "doc.md:3:13: menu_order: cannot unmarshal !!str `first` into int"},
+		{"nested type error", "---\ntitle: Install\ncategories:\n  - [a, b]\n---\n", "doc.md:4:3: categories: cannot unmarshal !!seq into string"},
+		{"yaml syntax", "---\ntitle: Install\nslug: [install\n---\n", "doc.md:3: did not find expected ',' or ']'"},
+		{"yaml scanner error", "---\ntitle: Install\nslug: @install\n---\n", "doc.md:3: found character that cannot start any token"},
+		{"not a mapping", "---\n- title\n---\n", "doc.md:2:1: front matter must be a mapping of fields"},
+		{"invalid value", "---\ntitle: Install\n  \nstatus: published\n---\n", `doc.md:4:1: invalid status "published": must be draft, pending, private or publish`},
+		{"bom and crlf", "\xef\xbb\xbf---\r\ntitle: Install\r\nauthors: [me]\r\n---\r\nText\r\n", `doc.md:3:1: unknown field "authors"`},
+		{"toml unknown field", "+++\ntitle = \"Install\"\n  authors = [\"me\"]\n+++\n", `doc.md:3:3: unknown field "authors"`},
+		{"toml type error", "+++\ntitle = \"Install\"\nmenu_order = \"first\"\n+++\n", "doc.md:3:1: menu_order: incompatible types: TOML value has type string; destination has type integer"},
+		{"toml parse error", "+++\ntitle = \"Install\"\nslug = install\n+++\n", `doc.md:3:8: expected value but found "nstall" instead`},
+	}
+	for _, test := range tests {
+		_, _, err := parseFrontMatter("doc.md", []byte(test.content), nil)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%s: got %v, want %s", test.name, err, test.want)
+		}
+	}
+}
+
+func TestParseFrontMatter(t *testing.T) {
+	for _, content := range []string{
+		"\xef\xbb\xbf---\r\ntitle: Install\r\nmenu_order: 3\r\ndate: 2021-03-04 09:00:00\r\n---\r\nText\r\n",
+		"+++\ntitle = \"Install\"\nmenu_order = 3\ndate = 2021-03-04T09:00:00\n+++\nText",
+	} {
+		frontMatter, body, err := parseFrontMatter("doc.md", []byte(content), nil)
+		if err != nil {
+			t.Fatalf("%q: %v", content, err)
+		}
+		if frontMatter.Title != "Install" || frontMatter.MenuOrder != 3 || string(body) != "Text\n" {
+			t.Errorf("%q: got %+v and body %q", content, frontMatter, body)
+		}
+		if date := frontMatter.Date.Format("2006-01-02T15:04:05Z07:00"); date != "2021-03-04T09:00:00Z" {
+			t.Errorf("%q: date %s, want UTC", content, date)
+		}
+		if p := frontMatter.positions["menu_order"]; p != (position{3, 1}) {
+			t.Errorf("%q: menu_order at %+v, want 3:1", content, p)
+		}
+	}
+}
@@ -1,27 +1,180 @@
 package wordepress
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/hex"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"path"
+	"strings"
 )
 
+// Image is an image, or other file linked to, uploaded as an attachment
 type Image struct {
 	Filename  string
 	Extension string
 	MimeType  string
 	Hash      string
-	Content   []byte
+
+	// Content is the processed content to upload, or nil to upload the file
+	// as it is
+	Content []byte
+	Size    int64
+
+	// Intrinsic dimensions, if known
+	Width  int
+	Height int
+
+	// Alt text and title given where the image is referenced
+	Alt   string
+	Title string
+
+	// Variants are downscaled copies of the image offered via srcset
+	Variants []*Image
+
+	// Media is the image's attachment, once uploaded
+	Media *Media
+}
+
+// Name is the filename under which an image is uploaded
+func (i *Image) Name() string {
+	return i.Hash + i.Extension
+}
+
+// placeholder is the src given to references to an image until its URL is
+// known
+func (i *Image) placeholder() string {
+	return "/wp-content/uploads/" + i.Name()
 }
 
-func ReadImage(filename string) (*Image, error) {
-	content, err := ioutil.ReadFile(filename)
+// sniffSize is how much of an image is read to determine its type and
+// dimensions without reading it all
+const sniffSize = 64 << 10
+
+// Open returns a reader of the image's content, from disk unless it had to be
+// processed
+func (i *Image) Open() (io.ReadCloser, error) {
+	if i.Content != nil {
+		return ioutil.NopCloser(bytes.NewReader(i.Content)), nil
+	}
+	return os.Open(i.Filename)
+}
+
+// ReadImage reads and processes an image. It is identified by the hash of the
+// processed content, so that changing the options uploads it afresh. SVGs are
+// sanitised rather than processed. Only content that differs from the file is
+// kept in memory.
+func ReadImage(filename string, options ImageOptions) (*Image, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	head, err := readHead(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// SVGs sniff as text
+	mimeType := http.DetectContentType(head)
+	if !options.processing() && len(options.SrcsetWidths) == 0 && !strings.HasPrefix(mimeType, "text/") {
+		return streamImage(filename, file, head, mimeType)
+	}
+
+	rest, err := ioutil.ReadAll(file)
 	if err != nil {
 		return nil, err
 	}
+	original := append(head, rest...)
+
+	if isSVG(original) {
+		content, err := sanitiseSVG(original)
+		if err != nil {
+			return nil, fmt.Errorf("sanitising %s: %v", filename, err)
+		}
+		width, height := svgDimensions(content)
+		image := newImage(filename, content, width, height)
+		image.MimeType = svgMimeType
+		return image, nil
+	}
+
+	content, width, height, err := processImage(original, options)
+	if err != nil {
+		return nil, fmt.Errorf("processing %s: %v", filename, err)
+	}
+
+	image := newImage(filename, content, width, height)
 
+	if len(options.SrcsetWidths) > 0 {
+		scaled, err := variants(content, options)
+		if err != nil {
+			return nil, fmt.Errorf("resizing %s: %v", filename, err)
+		}
+		for _, v := range scaled {
+			// Named for the sake of the attachment title
+			name := fmt.Sprintf("%s-%dw%s", strings.TrimSuffix(filename, image.Extension), v.width, image.Extension)
+			image.Variants = append(image.Variants, newImage(name, v.content, v.width, v.height))
+		}
+	}
+
+	if bytes.Equal(content, original) {
+		image.Content = nil
+	}
+	return image, nil
+}
+
+// streamImage describes an image to be uploaded as it is, without reading
+// more of it into memory than head
+func streamImage(filename string, file *os.File, head []byte, mimeType string) (*Image, error) {
+	_, _, width, height, err := imageInfo(io.MultiReader(bytes.NewReader(head), file), head)
+	if err != nil {
+		// Not an image we can measure, but upload it all the same
+		width, height = 0, 0
+	}
+
+	hash, size, err := hashFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Image{
+		Filename:  filename,
+		Extension: path.Ext(filename),
+		MimeType:  mimeType,
+		Hash:      hash,
+		Size:      size,
+		Width:     width,
+		Height:    height}, nil
+}
+
+func readHead(file *os.File) ([]byte, error) {
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// hashFile returns the SHA1 and size of a file's entire content
+func hashFile(file *os.File) (string, int64, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	hash := sha1.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+func newImage(filename string, content []byte, width, height int) *Image {
 	sum := sha1.Sum(content)
 
 	return &Image{
@@ -29,5 +182,54 @@ func ReadImage(filename string) (*Image, error) {
 		Extension: path.Ext(filename),
 		MimeType:  http.DetectContentType(content),
 		Hash:      hex.EncodeToString(sum[:]),
-		Content:   content}, nil
+		Content:   content,
+		Size:      int64(len(content)),
+		Width:     width,
+		Height:    height}
+}
+
+// imageCache deduplicates the images of a site, so that each file is read
+// once however many documents reference it, and identical files are uploaded
+// once whatever they're called
+type imageCache struct {
+	byPath map[string]*Image
+	byHash map[string]*Image
+	listed map[*Image]bool
+
+	// Every distinct image and variant referenced, in order of first reference
+	images []*Image
+}
+
+func newImageCache() *imageCache {
+	return &imageCache{
+		byPath: make(map[string]*Image),
+		byHash: make(map[string]*Image),
+		listed: make(map[*Image]bool)}
+}
+
+func (c *imageCache) read(filename string, read func(filename string) (*Image, error)) (*Image, error) {
+	if image, ok := c.byPath[filename]; ok {
+		return image, nil
+	}
+
+	image, err := read(filename)
+	if err != nil {
+		return nil, err
+	}
+	if existing, ok := c.byHash[image.Hash]; ok {
+		image = existing
+	} else {
+		c.byHash[image.Hash] = image
+	}
+	c.byPath[filename] = image
+	return image, nil
+}
+
+// reference lists an image and its variants for upload
+func (c *imageCache) reference(image *Image) {
+	if !c.listed[image] {
+		c.listed[image] = true
+		c.images = append(c.images, image)
+		c.images = append(c.images, image.Variants...)
+	}
 }
@@ -8,18 +8,56 @@ type Text struct {
 type Document struct {
 	LocalParent    *Document `json:"-"`
 	RemoteDocument *Document `json:"-"`
+	Images         []*Image  `json:"-"`
 
 	ID        int    `json:"id,omitempty"`
 	Title     Text   `json:"title"`
 	Content   Text   `json:"content"`
+	Excerpt   Text   `json:"excerpt"`
 	Parent    int    `json:"parent"`
 	MenuOrder int    `json:"menu_order"`
 	Slug      string `json:"slug"`
-	Product   string `json:"wpcf-product"`
-	Version   string `json:"wpcf-version"`
-	Name      string `json:"wpcf-name"`
-	Tag       string `json:"wpcf-tag"`
 	Status    string `json:"status"`
+
+	// Optional post properties, left as they are in WordPress unless given
+	Author        int    `json:"author,omitempty"`
+	Date          string `json:"date_gmt,omitempty"`
+	CommentStatus string `json:"comment_status,omitempty"`
+	Template      string `json:"template,omitempty"`
+	FeaturedMedia int    `json:"featured_media,omitempty"`
+	Categories    []int  `json:"categories,omitempty"`
+	Tags          []int  `json:"tags,omitempty"`
+
+	// Given locally by name or path, and resolved to the IDs above once
+	// known to WordPress
+	AuthorSlug    string   `json:"-"`
+	FeaturedImage *Image   `json:"-"`
+	CategoryNames []string `json:"-"`
+	TagNames      []string `json:"-"`
+
+	// Modified is set by WordPress, and so never sent
+	Modified string `json:"modified_gmt,omitempty"`
+
+	// Stored in the fields named by the client's FieldNames
+	Product string `json:"-"`
+	Version string `json:"-"`
+	Name    string `json:"-"`
+	Tag     string `json:"-"`
+
+	// Recorded is held by the checksum field: the modified_gmt of the
+	// document as last published, or RecordModified when publishing it
+	Recorded string `json:"-"`
+
+	// Link is the URL of a page standing in for a link to elsewhere in the
+	// site's navigation
+	Link string `json:"-"`
+
+	// Meta holds the values of the client's custom fields, by front matter key
+	Meta map[string]interface{} `json:"-"`
+
+	// sitePath is the path of the document's file within the site, without
+	// extension, as used by links from other documents
+	sitePath string
 }
 
 type MediaDetails struct {
@@ -27,5 +65,10 @@ type MediaDetails struct {
 }
 
 type Media struct {
+	ID           int          `json:"id"`
+	Slug         string       `json:"slug"`
+	SourceURL    string       `json:"source_url"`
+	Date         string       `json:"date_gmt"`
+	Post         int          `json:"post"`
 	MediaDetails MediaDetails `json:"media_details"`
 }
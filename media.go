@@ -0,0 +1,244 @@
+package wordepress
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// mediaLookupSize bounds the number of slugs looked up in a single listing, so
+// as to keep the URL to a reasonable length
+const mediaLookupSize = 50
+
+// LookupImages finds the attachments previously uploaded for images, listing
+// the media library for many at once. The results are cached for use by
+// FindImage.
+func (c *Client) LookupImages(ctx context.Context, images []*Image) error {
+	var hashes []string
+	seen := make(map[string]bool)
+	c.mediaMu.Lock()
+	for _, image := range images {
+		if _, ok := c.media[image.Hash]; !ok && !seen[image.Hash] {
+			hashes = append(hashes, image.Hash)
+			seen[image.Hash] = true
+		}
+	}
+	c.mediaMu.Unlock()
+
+	for start := 0; start < len(hashes); start += mediaLookupSize {
+		end := start + mediaLookupSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		found, err := c.findMedia(ctx, hashes[start:end])
+		if err != nil {
+			return err
+		}
+
+		for _, hash := range hashes[start:end] {
+			c.cacheMedia(hash, found[hash])
+		}
+	}
+	return nil
+}
+
+// FindImage returns the attachment previously uploaded for an image, or nil if
+// there isn't one
+func (c *Client) FindImage(ctx context.Context, image *Image) (*Media, error) {
+	err := c.LookupImages(ctx, []*Image{image})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mediaMu.Lock()
+	defer c.mediaMu.Unlock()
+	return c.media[image.Hash], nil
+}
+
+// findMedia lists the attachments with the given image hashes, which uploads
+// are given as their slug
+func (c *Client) findMedia(ctx context.Context, hashes []string) (map[string]*Media, error) {
+	url := fmt.Sprintf("%s?slug=%s&per_page=%d&_fields=id,slug,source_url,post,media_details",
+		c.MediaEndpoint(), strings.Join(hashes, ","), len(hashes))
+	request, err := c.newRequest(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, responseBytes, err := c.do(request, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, newAPIError(response, responseBytes)
+	}
+
+	var media []*Media
+	err = json.Unmarshal(responseBytes, &media)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]*Media)
+	for _, m := range media {
+		if m.SourceURL != "" {
+			found[m.Slug] = m
+		}
+	}
+	return found, nil
+}
+
+// PostImage uploads an image, returning the resulting attachment. The
+// attachment is titled after the image's original filename, with alt text
+// and caption taken from its alt text and title. Its slug is the image hash,
+// which WordPress would otherwise derive from the title.
+func (c *Client) PostImage(ctx context.Context, image *Image) (*Media, error) {
+	metadata := url.Values{}
+	metadata.Set("slug", image.Hash)
+	metadata.Set("title", strings.TrimSuffix(path.Base(image.Filename), image.Extension))
+	if image.Alt != "" {
+		metadata.Set("alt_text", image.Alt)
+	}
+	if image.Title != "" {
+		metadata.Set("caption", image.Title)
+	}
+
+	body, err := image.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := c.newRequest(ctx, "POST", c.MediaEndpoint()+"?"+metadata.Encode(), body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	// Streamed from disk, so the length and the means of retrying are unknown
+	// to http.NewRequest
+	request.ContentLength = image.Size
+	request.GetBody = image.Open
+	request.Header.Set("Content-Type", image.MimeType)
+	request.Header.Set("Content-Disposition", `attachment; filename="`+image.Name()+`"`)
+
+	var applied *Media
+	probe := func(ctx context.Context) (bool, error) {
+		found, err := c.findMedia(ctx, []string{image.Hash})
+		applied = found[image.Hash]
+		return applied != nil, err
+	}
+
+	response, responseBytes, err := c.do(request, probe)
+	if err == errApplied {
+		c.cacheMedia(image.Hash, applied)
+		return applied, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusCreated {
+		return nil, newAPIError(response, responseBytes)
+	}
+
+	var media Media
+	err = json.Unmarshal(responseBytes, &media)
+	if err != nil {
+		return nil, err
+	}
+
+	// WordPress renames uploads that clash with an existing file, which also
+	// changes the slug derived from the name
+	if media.Slug != image.Hash {
+		return nil, fmt.Errorf("duplicate attachment: requested %s, response %s",
+			image.Name(), media.MediaDetails.File)
+	}
+
+	c.cacheMedia(image.Hash, &media)
+	return &media, nil
+}
+
+func (c *Client) cacheMedia(hash string, media *Media) {
+	c.mediaMu.Lock()
+	defer c.mediaMu.Unlock()
+	if c.media == nil {
+		c.media = make(map[string]*Media)
+	}
+	c.media[hash] = media
+}
+
+// AttachImage attaches an attachment to the document with the given ID, which
+// WordPress then lists as the attachment's "Uploaded to" post
+func (c *Client) AttachImage(ctx context.Context, media *Media, documentID int) error {
+	requestBytes, err := json.Marshal(map[string]int{"post": documentID})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/%d", c.MediaEndpoint(), media.ID)
+	request, err := c.newRequest(ctx, "POST", url, bytes.NewReader(requestBytes))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, responseBytes, err := c.do(request, nil)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return newAPIError(response, responseBytes)
+	}
+
+	media.Post = documentID
+	return nil
+}
+
+// GetMedia lists every attachment matching query
+func (c *Client) GetMedia(ctx context.Context, query string) ([]*Media, error) {
+	pages, err := c.getPages(ctx, c.MediaEndpoint(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	var media []*Media
+	for _, page := range pages {
+		for _, object := range page {
+			m := &Media{}
+			err = json.Unmarshal(object, m)
+			if err != nil {
+				return nil, err
+			}
+			media = append(media, m)
+		}
+	}
+	return media, nil
+}
+
+// DeleteMedia permanently deletes an attachment and its files; attachments
+// can't be trashed through the REST API
+func (c *Client) DeleteMedia(ctx context.Context, media *Media) error {
+	url := fmt.Sprintf("%s/%d?force=true", c.MediaEndpoint(), media.ID)
+	request, err := c.newRequest(ctx, "DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+
+	response, responseBytes, err := c.do(request, nil)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return newAPIError(response, responseBytes)
+	}
+
+	c.cacheMedia(media.Slug, nil)
+	return nil
+}
@@ -0,0 +1,120 @@
+package wordepress
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPostImageFoundByHash(t *testing.T) {
+	client, server := newTestClient(t)
+	ctx := context.Background()
+
+	image := newImage("site/images/Network Diagram.png", []byte("not really a PNG"), 0, 0)
+	image.Alt, image.Title = "The network", "How it fits together"
+
+	media, err := client.PostImage(ctx, image)
+	if err != nil {
+		t.Fatalf("PostImage: %v", err)
+	}
+	if media.Slug != image.Hash {
+		t.Errorf("slug %q, want the hash %q", media.Slug, image.Hash)
+	}
+
+	record := server.Posts("media")[0]
+	if title := record["title"].(map[string]interface{})["raw"]; title != "Network Diagram" {
+		t.Errorf("title %q, want the original filename", title)
+	}
+	if record["alt_text"] != "The network" {
+		t.Errorf("alt text %q", record["alt_text"])
+	}
+
+	// A fresh client has to find the upload in the media library
+	client = NewClient(server.URL, nil)
+	found, err := client.FindImage(ctx, image)
+	if err != nil {
+		t.Fatalf("FindImage: %v", err)
+	}
+	if found == nil || found.ID != media.ID {
+		t.Fatalf("FindImage found %v, want attachment %d", found, media.ID)
+	}
+}
+
+func TestPostImageDuplicate(t *testing.T) {
+	client, _ := newTestClient(t)
+	ctx := context.Background()
+
+	image := newImage("a.png", []byte("content"), 0, 0)
+	if _, err := client.PostImage(ctx, image); err != nil {
+		t.Fatalf("PostImage: %v", err)
+	}
+	// Uploading again clashes with the first attachment's slug and file
+	if _, err := client.PostImage(ctx, image); err == nil {
+		t.Fatal("expected duplicate attachment error")
+	}
+}
+
+func TestLookupImages(t *testing.T) {
+	client, server := newTestClient(t)
+	ctx := context.Background()
+
+	var images []*Image
+	uploaded := make(map[string]int)
+	for i := 0; i < 3; i++ {
+		image := newImage(fmt.Sprintf("image%d.png", i), []byte(fmt.Sprintf("image %d", i)), 0, 0)
+		media, err := client.PostImage(ctx, image)
+		if err != nil {
+			t.Fatalf("PostImage: %v", err)
+		}
+		uploaded[image.Hash] = media.ID
+		images = append(images, image)
+	}
+	missing := newImage("missing.png", []byte("not uploaded"), 0, 0)
+	// The same file under another name
+	copied := newImage("copy.png", []byte("image 0"), 0, 0)
+	images = append(images, missing, copied)
+
+	// A fresh client counting its lookups
+	var lookups int32
+	client = NewClient(server.URL, nil)
+	client.HTTPClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/media") {
+			atomic.AddInt32(&lookups, 1)
+		}
+		return http.DefaultTransport.RoundTrip(r)
+	})
+
+	if err := client.LookupImages(ctx, images); err != nil {
+		t.Fatalf("LookupImages: %v", err)
+	}
+	if lookups != 1 {
+		t.Errorf("%d lookups, want every hash in one", lookups)
+	}
+
+	for _, image := range images {
+		media, err := client.FindImage(ctx, image)
+		if err != nil {
+			t.Fatalf("FindImage: %v", err)
+		}
+		switch {
+		case image == missing && media != nil:
+			t.Errorf("found %s", image.Name())
+		case image != missing && (media == nil || media.ID != uploaded[image.Hash]):
+			t.Errorf("%s found %v, want attachment %d", image.Name(), media, uploaded[image.Hash])
+		}
+	}
+
+	// A miss is remembered, and the upload then found without asking again
+	if _, err := client.PostImage(ctx, missing); err != nil {
+		t.Fatalf("PostImage: %v", err)
+	}
+	if media, err := client.FindImage(ctx, missing); err != nil || media == nil {
+		t.Errorf("FindImage after upload: %v, %v", media, err)
+	}
+	if lookups != 1 {
+		t.Errorf("%d lookups, want no more after the first", lookups)
+	}
+}
@@ -0,0 +1,115 @@
+package wordepress
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// MetaType is the type of a custom field's value
+type MetaType string
+
+const (
+	MetaString MetaType = "string"
+	MetaNumber MetaType = "number"
+	MetaBool   MetaType = "bool"
+	MetaList   MetaType = "list"
+)
+
+// MetaField declares a custom front matter key and the WordPress field, named
+// as in FieldNames, in which its value is published. Values are held in
+// Document.Meta as a string, float64, bool or []string according to Type.
+type MetaField struct {
+	Key   string
+	Field string
+	Type  MetaType
+}
+
+// ParseMetaField parses a custom field declared as "key[=field][:type]", the
+// field defaulting to "meta.<key>" and the type to string
+func ParseMetaField(declaration string) (MetaField, error) {
+	field := MetaField{Type: MetaString}
+	if i := strings.LastIndex(declaration, ":"); i >= 0 {
+		field.Type = MetaType(declaration[i+1:])
+		declaration = declaration[:i]
+	}
+	field.Key = declaration
+	if i := strings.Index(declaration, "="); i >= 0 {
+		field.Key, field.Field = declaration[:i], declaration[i+1:]
+	} else {
+		field.Field = metaPrefix + field.Key
+	}
+
+	switch field.Type {
+	case MetaString, MetaNumber, MetaBool, MetaList:
+	default:
+		return field, fmt.Errorf("invalid type %q of custom field %s: must be string, number, bool or list", field.Type, field.Key)
+	}
+	if field.Key == "" || field.Field == "" || field.Field == metaPrefix {
+		return field, fmt.Errorf("invalid custom field %q: expected key[=field][:type]", declaration)
+	}
+	return field, nil
+}
+
+// description is how a type is named to those writing front matter
+func (t MetaType) description() string {
+	switch t {
+	case MetaNumber:
+		return "a number"
+	case MetaBool:
+		return "true or false"
+	case MetaList:
+		return "a list of strings"
+	}
+	return "a string"
+}
+
+// decode reads a value of the type from WordPress JSON
+func (t MetaType) decode(raw json.RawMessage) (interface{}, error) {
+	switch t {
+	case MetaNumber:
+		var value float64
+		err := json.Unmarshal(raw, &value)
+		return value, err
+	case MetaBool:
+		var value bool
+		err := json.Unmarshal(raw, &value)
+		return value, err
+	case MetaList:
+		var value []string
+		err := json.Unmarshal(raw, &value)
+		return value, err
+	}
+	var value string
+	err := json.Unmarshal(raw, &value)
+	return value, err
+}
+
+func metaEqual(a, b interface{}) bool {
+	listA, ok := a.([]string)
+	if !ok {
+		return a == b
+	}
+	listB, _ := b.([]string)
+	if len(listA) != len(listB) {
+		return false
+	}
+	for i := range listA {
+		if listA[i] != listB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SameMeta reports whether the custom fields given locally have the same
+// values remotely. Fields not given locally are left as they are in WordPress,
+// and so not compared.
+func (d *Document) SameMeta(remote *Document) bool {
+	for key, value := range d.Meta {
+		if !metaEqual(value, remote.Meta[key]) {
+			return false
+		}
+	}
+	return true
+}
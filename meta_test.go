@@ -0,0 +1,125 @@
+package wordepress
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMetaField(t *testing.T) {
+	tests := []struct {
+		declaration string
+		want        MetaField
+		err         string
+	}{
+		{"audience", MetaField{"audience", "meta.audience", MetaString}, ""},
+		{"weight:number", MetaField{"weight", "meta.weight", MetaNumber}, ""},
+		{"beta=meta.is_beta:bool", MetaField{"beta", "meta.is_beta", MetaBool}, ""},
+		{"platforms=wpcf-platforms:list", MetaField{"platforms", "wpcf-platforms", MetaList}, ""},
+		{"weight:integer", MetaField{}, `invalid type "integer" of custom field weight: must be string, number, bool or list`},
+		{"=meta.audience", MetaField{}, `invalid custom field "=meta.audience": expected key[=field][:type]`},
+		{"audience=", MetaField{}, `invalid custom field "audience=": expected key[=field][:type]`},
+		{"audience=meta.:string", MetaField{}, `invalid custom field "audience=meta.": expected key[=field][:type]`},
+	}
+	for _, test := range tests {
+		field, err := ParseMetaField(test.declaration)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got %v, want %s", test.declaration, err, test.err)
+			}
+			continue
+		}
+		if err != nil || field != test.want {
+			t.Errorf("%s: got %+v, %v, want %+v", test.declaration, field, err, test.want)
+		}
+	}
+}
+
+var testMetaFields = []MetaField{
+	{"audience", "meta.audience", MetaString},
+	{"weight", "meta.weight", MetaNumber},
+	{"beta", "meta.beta", MetaBool},
+	{"platforms", "meta.platforms", MetaList}}
+
+func TestFrontMatterMetaValues(t *testing.T) {
+	want := map[string]interface{}{
+		"audience":  "1.10",
+		"weight":    float64(2),
+		"beta":      true,
+		"platforms": []string{"linux", "2"}}
+	for _, content := range []string{
+		"---\ntitle: Install\naudience: 1.10\nweight: 2\nbeta: true\nplatforms: [linux, 2]\n---\n",
+		"+++\ntitle = \"Install\"\naudience = \"1.10\"\nweight = 2\nbeta = true\nplatforms = [\"linux\", \"2\"]\n+++\n",
+	} {
+		frontMatter, _, err := parseFrontMatter("doc.md", []byte(content), testMetaFields)
+		if err != nil {
+			t.Fatalf("%q: %v", content, err)
+		}
+		if !reflect.DeepEqual(frontMatter.meta, want) {
+			t.Errorf("%q: meta %#v, want %#v", content, frontMatter.meta, want)
+		}
+	}
+}
+
+func TestFrontMatterMetaErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"yaml string", "---\ntitle: Install\naudience: [admins]\n---\n", "doc.md:3:11: audience: expected a string"},
+		{"yaml number", "---\ntitle: Install\nweight: heavy\n---\n", "doc.md:3:9: weight: expected a number"},
+		{"yaml quoted number", "---\ntitle: Install\nweight: \"2\"\n---\n", "doc.md:3:9: weight: expected a number"},
+		{"yaml bool", "---\ntitle: Install\nbeta: yes\n---\n", "doc.md:3:7: beta: expected true or false"},
+		{"yaml list", "---\ntitle: Install\nplatforms: linux\n---\n", "doc.md:3:12: platforms: expected a list of strings"},
+		{"yaml nested list", "---\ntitle: Install\nplatforms: [[linux]]\n---\n", "doc.md:3:12: platforms: expected a list of strings"},
+		{"toml string", "+++\ntitle = \"Install\"\naudience = 1\n+++\n", "doc.md:3:1: audience: expected a string"},
+		{"toml number", "+++\ntitle = \"Install\"\n weight = \"2\"\n+++\n", "doc.md:3:2: weight: expected a number"},
+		{"toml bool", "+++\ntitle = \"Install\"\nbeta = \"true\"\n+++\n", "doc.md:3:1: beta: expected true or false"},
+		{"toml list", "+++\ntitle = \"Install\"\nplatforms = [1, 2]\n+++\n", "doc.md:3:1: platforms: expected a list of strings"},
+	}
+	for _, test := range tests {
+		_, _, err := parseFrontMatter("doc.md", []byte(test.content), testMetaFields)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%s: got %v, want %s", test.name, err, test.want)
+		}
+	}
+}
+
+func TestTOMLMetaValue(t *testing.T) {
+	tests := []struct {
+		t    MetaType
+		raw  interface{}
+		want interface{}
+		ok   bool
+	}{
+		{MetaString, "linux", "linux", true},
+		{MetaString, int64(1), nil, false},
+		{MetaNumber, int64(2), float64(2), true},
+		{MetaNumber, 2.5, 2.5, true},
+		{MetaNumber, "2", nil, false},
+		{MetaBool, false, false, true},
+		{MetaBool, "false", nil, false},
+		{MetaList, []interface{}{"a", "b"}, []string{"a", "b"}, true},
+		{MetaList, []interface{}{}, []string{}, true},
+		{MetaList, []interface{}{"a", int64(1)}, nil, false},
+		{MetaList, "a", nil, false},
+	}
+	for _, test := range tests {
+		value, ok := tomlMetaValue(test.t, test.raw)
+		if ok != test.ok || (ok && !reflect.DeepEqual(value, test.want)) {
+			t.Errorf("%s %#v: got %#v, %v, want %#v, %v", test.t, test.raw, value, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestSameMeta(t *testing.T) {
+	local := &Document{Meta: map[string]interface{}{"platforms": []string{"linux"}, "weight": float64(2)}}
+	remote := &Document{Meta: map[string]interface{}{"platforms": []string{"linux"}, "weight": float64(2), "beta": true}}
+	if !local.SameMeta(remote) {
+		t.Error("fields only set remotely should be ignored")
+	}
+	remote.Meta["platforms"] = []string{"linux", "darwin"}
+	if local.SameMeta(remote) {
+		t.Error("differing lists compared the same")
+	}
+}
@@ -0,0 +1,356 @@
+package wordepress
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	stdhtml "html"
+	"io/ioutil"
+	"log"
+	"os"
+	stdpath "path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// navFileNames are the files at the root of a site that may declare its
+// navigation, rather than it following the layout of the files
+var navFileNames = []string{"nav.yaml", "nav.yml", "SUMMARY.md"}
+
+// navEntry is a page of a site's navigation, or a link to elsewhere
+type navEntry struct {
+	// Title and Slug override those given by the page
+	Title string
+	Slug  string
+
+	// Page is the path of a markdown file relative to the site root, and
+	// URL the target of a link instead
+	Page string
+	URL  string
+
+	Children []*navEntry
+
+	// Where the entry was declared
+	line, column int
+}
+
+var summaryItemRegexp = regexp.MustCompile(`^( *)(?:[-*+] +)?\[(.*)\]\((.*)\)$`)
+
+// readNav returns the navigation declared at the root of a site, or nil if it
+// has none. Every page listed must exist, and markdown files that aren't
+// listed are reported.
+func readNav(root string) ([]*navEntry, error) {
+	var filename string
+	for _, name := range navFileNames {
+		if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+			if filename != "" {
+				return nil, fmt.Errorf("both %s and %s declare the navigation of %s", filename, name, root)
+			}
+			filename = name
+		}
+	}
+	if filename == "" {
+		return nil, nil
+	}
+
+	path := filepath.Join(root, filename)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	content = bytes.TrimPrefix(content, utf8BOM)
+
+	log.Printf("Loading navigation from %s", path)
+	var entries []*navEntry
+	if filename == "SUMMARY.md" {
+		entries, err = parseSummary(path, content)
+	} else {
+		entries, err = parseYAMLNav(path, content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entries, checkNav(root, path, entries)
+}
+
+func navError(filename string, line, column int, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d:%d: %s", filename, line, column, fmt.Sprintf(format, args...))
+}
+
+// target sets the page or URL of an entry from a link in the navigation
+func (e *navEntry) target(link string) {
+	if strings.Contains(link, "://") || strings.HasPrefix(link, "mailto:") {
+		e.URL = link
+	} else if link != "" {
+		e.Page = stdpath.Clean(strings.TrimPrefix(link, "/"))
+	}
+}
+
+// parseYAMLNav parses a list of entries, optionally under a "nav" key as in
+// mkdocs.yml. Each is either the path of a page, a single "Title: target"
+// pair, or a mapping of title, page or url, slug and children.
+func parseYAMLNav(filename string, content []byte) ([]*navEntry, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		e := yamlError(err, 1)
+		return nil, fmt.Errorf("%s:%d: %s", filename, e.Line, e.Message)
+	}
+	if len(root.Content) == 0 {
+		return nil, fmt.Errorf("%s: empty navigation", filename)
+	}
+
+	list := root.Content[0]
+	if list.Kind == yaml.MappingNode && len(list.Content) == 2 && list.Content[0].Value == "nav" {
+		list = list.Content[1]
+	}
+	return yamlNavEntries(filename, list)
+}
+
+func yamlNavEntries(filename string, list *yaml.Node) ([]*navEntry, error) {
+	if list.Kind != yaml.SequenceNode {
+		return nil, navError(filename, list.Line, list.Column, "expected a list of pages")
+	}
+
+	var entries []*navEntry
+	for _, item := range list.Content {
+		entry := &navEntry{line: item.Line, column: item.Column}
+		switch {
+		case item.Kind == yaml.ScalarNode:
+			entry.target(item.Value)
+
+		case item.Kind == yaml.MappingNode && len(item.Content) == 2 && !navKeys[item.Content[0].Value]:
+			// Title: target
+			entry.Title = item.Content[0].Value
+			value := item.Content[1]
+			if value.Kind != yaml.ScalarNode {
+				return nil, navError(filename, value.Line, value.Column,
+					"%s: expected the page or URL (give a section's page and children as page: and children:)", entry.Title)
+			}
+			entry.target(value.Value)
+
+		case item.Kind == yaml.MappingNode:
+			for i := 0; i+1 < len(item.Content); i += 2 {
+				key, value := item.Content[i], item.Content[i+1]
+				if !navKeys[key.Value] {
+					return nil, navError(filename, key.Line, key.Column, "unknown field %q", key.Value)
+				}
+				if key.Value == "children" {
+					children, err := yamlNavEntries(filename, value)
+					if err != nil {
+						return nil, err
+					}
+					entry.Children = children
+					continue
+				}
+				if value.Kind != yaml.ScalarNode {
+					return nil, navError(filename, value.Line, value.Column, "%s: expected a string", key.Value)
+				}
+				switch key.Value {
+				case "title":
+					entry.Title = value.Value
+				case "slug":
+					entry.Slug = value.Value
+				case "page":
+					entry.Page = stdpath.Clean(strings.TrimPrefix(value.Value, "/"))
+				case "url":
+					entry.URL = value.Value
+				}
+			}
+
+		default:
+			return nil, navError(filename, item.Line, item.Column, "expected a page, \"Title: page\" or a mapping")
+		}
+
+		if err := entry.validate(filename); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+var navKeys = map[string]bool{"title": true, "slug": true, "page": true, "url": true, "children": true}
+
+// parseSummary parses a SUMMARY.md as used by GitBook and mdBook: a nested
+// list of links to pages or elsewhere. Headings and separators are ignored.
+func parseSummary(filename string, content []byte) ([]*navEntry, error) {
+	type level struct {
+		indent int
+		entry  *navEntry
+	}
+	var (
+		entries []*navEntry
+		stack   []level
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(strings.Replace(scanner.Text(), "\t", "    ", -1), " \r")
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.Trim(trimmed, "-") == "" {
+			continue
+		}
+
+		match := summaryItemRegexp.FindStringSubmatch(text)
+		if match == nil {
+			return nil, navError(filename, line, len(text)-len(strings.TrimLeft(text, " "))+1,
+				"expected a link such as - [Title](page.md)")
+		}
+		indent := len(match[1])
+		entry := &navEntry{Title: match[2], line: line, column: indent + 1}
+		entry.target(match[3])
+		if err := entry.validate(filename); err != nil {
+			return nil, err
+		}
+
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			entries = append(entries, entry)
+		} else {
+			parent := stack[len(stack)-1].entry
+			parent.Children = append(parent.Children, entry)
+		}
+		stack = append(stack, level{indent, entry})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s: empty navigation", filename)
+	}
+	return entries, nil
+}
+
+func (e *navEntry) validate(filename string) error {
+	switch {
+	case e.Page == "" && e.URL == "":
+		return navError(filename, e.line, e.column, "%q has no page or URL", e.Title)
+	case e.Page != "" && e.URL != "":
+		return navError(filename, e.line, e.column, "%q has both a page and a URL", e.Title)
+	case e.URL != "" && e.Title == "":
+		return navError(filename, e.line, e.column, "link to %s has no title", e.URL)
+	case e.URL != "" && len(e.Children) > 0:
+		return navError(filename, e.line, e.column, "link to %s can't have children", e.URL)
+	case e.Page != "" && !strings.HasSuffix(e.Page, ".md"):
+		return navError(filename, e.line, e.column, "%s is not a markdown file", e.Page)
+	case e.Page == ".." || strings.HasPrefix(e.Page, "../"):
+		return navError(filename, e.line, e.column, "%s is outside the site", e.Page)
+	}
+	return nil
+}
+
+// checkNav fails if a page listed by the navigation doesn't exist, and reports
+// the markdown files it doesn't list, which aren't published
+func checkNav(root, filename string, entries []*navEntry) error {
+	listed := make(map[string]bool)
+	var check func(entries []*navEntry) error
+	check = func(entries []*navEntry) error {
+		for _, entry := range entries {
+			if entry.Page != "" {
+				if _, err := os.Stat(filepath.Join(root, entry.Page)); err != nil {
+					return navError(filename, entry.line, entry.column, "%s doesn't exist", entry.Page)
+				}
+				listed[entry.Page] = true
+			}
+			if err := check(entry.Children); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := check(entries); err != nil {
+		return err
+	}
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != root && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".md") || path == filename {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if !listed[filepath.ToSlash(rel)] {
+			log.Printf("%s isn't listed in %s, so won't be published", path, filename)
+		}
+		return nil
+	})
+}
+
+// parseNav parses the documents of a site in the order and hierarchy declared
+// by its navigation. A page listed more than once is published at each place,
+// its later copies named after their parent unless the entry gives a slug.
+func parseNav(product, version, tag, root string, entries []*navEntry, parent *Document, options ParseOptions, cache *imageCache, seen map[string]bool) ([]*Document, error) {
+	var documents []*Document
+	for i, entry := range entries {
+		var document *Document
+		var err error
+		if entry.URL != "" {
+			document, err = linkDocument(product, version, tag, entry, parent)
+		} else {
+			file := filepath.Join(root, entry.Page)
+			document, err = parseFile(product, version, tag, file, i+1, entry, parent, options, cache)
+			if _, ok := err.(*FrontMatterError); ok {
+				return nil, err
+			}
+			if err != nil {
+				return nil, fmt.Errorf("parse %v: %v", file, err)
+			}
+			if seen[entry.Page] && entry.Slug == "" && parent != nil {
+				document.Name = parent.Name + "-" + document.Name
+				document.Slug, err = sanitiseSlug(qualifySlug(product, tag, document.Name))
+			}
+			seen[entry.Page] = true
+		}
+		if err != nil {
+			return nil, err
+		}
+		document.MenuOrder = i + 1
+		documents = append(documents, document)
+
+		children, err := parseNav(product, version, tag, root, entry.Children, document, options, cache, seen)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, children...)
+	}
+	return documents, nil
+}
+
+// linkDocument returns the document standing in for a link in the navigation,
+// which links to its target for the sake of themes that don't know to follow
+// its Link field
+func linkDocument(product, version, tag string, entry *navEntry, parent *Document) (*Document, error) {
+	base := entry.Slug
+	if base == "" {
+		base = termSlug(entry.Title)
+	}
+	slug, err := sanitiseSlug(qualifySlug(product, tag, base))
+	if err != nil {
+		return nil, fmt.Errorf("link %q: %v", entry.Title, err)
+	}
+
+	return &Document{
+		LocalParent: parent,
+		Title:       Text{Raw: entry.Title},
+		Content: Text{Raw: fmt.Sprintf("<p><a href=\"%s\">%s</a></p>\n",
+			stdhtml.EscapeString(entry.URL), stdhtml.EscapeString(entry.Title))},
+		Product: product,
+		Version: version,
+		Name:    base,
+		Tag:     tag,
+		Slug:    slug,
+		Status:  "publish",
+		Link:    entry.URL}, nil
+}
@@ -0,0 +1,177 @@
+package wordepress
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// describeNav lists entries one per line, indented by depth, as
+// title|page|url|slug@line:column
+func describeNav(entries []*navEntry, depth int) []string {
+	var lines []string
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("%s%s|%s|%s|%s@%d:%d",
+			strings.Repeat("  ", depth), e.Title, e.Page, e.URL, e.Slug, e.line, e.column))
+		lines = append(lines, describeNav(e.Children, depth+1)...)
+	}
+	return lines
+}
+
+func TestParseSummary(t *testing.T) {
+	content := "# Summary\n\n[Introduction](README.md)\n\n" +
+		"- [Install](install/index.md)\n" +
+		"  - [On Linux](/install/linux.md)\n" +
+		"\t- [On macOS](install/./macos.md)\n" +
+		"- [Configure](configure.md)\r\n" +
+		"---\n" +
+		"* [Community](https://example.com/community)\n"
+	entries, err := parseSummary("SUMMARY.md", []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"Introduction|README.md||@3:1",
+		"Install|install/index.md||@5:1",
+		"  On Linux|install/linux.md||@6:3",
+		"    On macOS|install/macos.md||@7:5",
+		"Configure|configure.md||@8:1",
+		"Community||https://example.com/community|@10:1"}
+	if got := describeNav(entries, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseYAMLNav(t *testing.T) {
+	content := "nav:\n" +
+		"  - index.md\n" +
+		"  - Install: install.md\n" +
+		"  - title: Configure\n" +
+		"    page: configure/index.md\n" +
+		"    slug: setup\n" +
+		"    children:\n" +
+		"      - configure/advanced.md\n" +
+		"  - Community: https://example.com/community\n" +
+		"  - title: Mail us\n" +
+		"    url: mailto:docs@example.com\n"
+	entries, err := parseYAMLNav("nav.yaml", []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"|index.md||@2:5",
+		"Install|install.md||@3:5",
+		"Configure|configure/index.md||setup@4:5",
+		"  |configure/advanced.md||@8:9",
+		"Community||https://example.com/community|@9:5",
+		"Mail us||mailto:docs@example.com|@10:5"}
+	if got := describeNav(entries, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Without the nav key
+	entries, err = parseYAMLNav("nav.yaml", []byte("- index.md\n- install.md\n"))
+	if err != nil || len(entries) != 2 {
+		t.Errorf("bare list: %v, %v", describeNav(entries, 0), err)
+	}
+}
+
+func TestNavErrors(t *testing.T) {
+	tests := []struct {
+		filename string
+		content  string
+		want     string
+	}{
+		{"nav.yaml", "", "nav.yaml: empty navigation"},
+		{"nav.yaml", "index.md\n", "nav.yaml:1:1: expected a list of pages"},
+		{"nav.yaml", "- [index.md]\n", `nav.yaml:1:3: expected a page, "Title: page" or a mapping`},
+		{"nav.yaml", "- Install:\n    page: install.md\n", "nav.yaml:2:5: Install: expected the page or URL (give a section's page and children as page: and children:)"},
+		{"nav.yaml", "- title: Install\n  pages: install.md\n", `nav.yaml:2:3: unknown field "pages"`},
+		{"nav.yaml", "- title: [Install]\n  page: install.md\n", "nav.yaml:1:10: title: expected a string"},
+		{"nav.yaml", "- title: Install\n", `nav.yaml:1:3: "Install" has no page or URL`},
+		{"nav.yaml", "- title: Install\n  page: install.md\n  url: https://example.com\n", `nav.yaml:1:3: "Install" has both a page and a URL`},
+		{"nav.yaml", "- url: https://example.com\n", "nav.yaml:1:3: link to https://example.com has no title"},
+		{"nav.yaml", "- title: Elsewhere\n  url: https://example.com\n  children: [a.md]\n", "nav.yaml:1:3: link to https://example.com can't have children"},
+		{"nav.yaml", "- install.html\n", "nav.yaml:1:3: install.html is not a markdown file"},
+		{"nav.yaml", "- ../README.md\n", "nav.yaml:1:3: ../README.md is outside the site"},
+		{"nav.yaml", "- a/../../README.md\n", "nav.yaml:1:3: ../README.md is outside the site"},
+		{"nav.yaml", "- index.md\n- [unclosed\n", "nav.yaml:2: did not find expected ',' or ']'"},
+		{"SUMMARY.md", "# Summary\n", "SUMMARY.md: empty navigation"},
+		{"SUMMARY.md", "- [Install](install.md)\n  - Configure\n", "SUMMARY.md:2:3: expected a link such as - [Title](page.md)"},
+		{"SUMMARY.md", "- [Install](../install.md)\n", "SUMMARY.md:1:1: ../install.md is outside the site"},
+		{"SUMMARY.md", "- [Install]()\n", `SUMMARY.md:1:1: "Install" has no page or URL`},
+	}
+	for _, test := range tests {
+		var err error
+		if test.filename == "SUMMARY.md" {
+			_, err = parseSummary(test.filename, []byte(test.content))
+		} else {
+			_, err = parseYAMLNav(test.filename, []byte(test.content))
+		}
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%q: got %v, want %s", test.content, err, test.want)
+		}
+	}
+}
+
+func TestCheckNav(t *testing.T) {
+	dir := t.TempDir()
+	writeSite(t, dir, map[string]string{
+		"nav.yaml":         "- index.md\n- install/index.md\n- missing.md\n",
+		"index.md":         "",
+		"install/index.md": ""})
+	filename := filepath.Join(dir, "nav.yaml")
+	entries, err := parseYAMLNav(filename, []byte("- index.md\n- install/index.md\n- missing.md\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filename + ":3:3: missing.md doesn't exist"
+	if err := checkNav(dir, filename, entries); err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+
+	if err := checkNav(dir, filename, entries[:2]); err != nil {
+		t.Errorf("checkNav: %v", err)
+	}
+}
+
+func TestParseSiteNav(t *testing.T) {
+	dir := t.TempDir()
+	writeSite(t, dir, map[string]string{
+		"SUMMARY.md": "- [Home](index.md)\n" +
+			"- [FAQ](faq.md)\n" +
+			"- [Setup](install.md)\n" +
+			"  - [Kubernetes](install/kubernetes.md)\n" +
+			"  - [FAQ](faq.md)\n" +
+			"- [Community](https://example.com/community)\n",
+		"index.md":              "---\ntitle: Index\n---\nSee [the FAQ](faq.md)\n",
+		"install.md":            "---\ntitle: Install\n---\n",
+		"install/kubernetes.md": "---\ntitle: On Kubernetes\n---\n",
+		"faq.md":                "---\ntitle: FAQ\n---\n",
+		"unlisted.md":           "---\ntitle: Unlisted\n---\n"})
+
+	documents, _, err := ParseSite("scope", "1.0", "v1.0", dir, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, document := range documents {
+		parent := ""
+		if document.LocalParent != nil {
+			parent = document.LocalParent.Slug
+		}
+		got = append(got, fmt.Sprintf("%s %q %d %s %s", document.Slug, document.Title.Raw, document.MenuOrder, parent, document.Link))
+	}
+	want := []string{
+		`scope-v1-0-index "Home" 1  `,
+		`scope-v1-0-faq "FAQ" 2  `,
+		`scope-v1-0-install "Setup" 3  `,
+		`scope-v1-0-kubernetes "Kubernetes" 1 scope-v1-0-install `,
+		`scope-v1-0-install-faq "FAQ" 2 scope-v1-0-install `,
+		`scope-v1-0-community "Community" 4  https://example.com/community`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,267 @@
+package wordepress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// jpegQuality is used when a JPEG must be re-encoded but no quality was given
+const jpegQuality = 90
+
+// ImageOptions configures the processing of PNG and JPEG images before they
+// are uploaded. The zero value leaves images untouched.
+type ImageOptions struct {
+	// MaxWidth downscales wider images to this width (0 for no limit)
+	MaxWidth int
+
+	// Quality re-encodes JPEGs at this quality (1-100), and PNGs with maximum
+	// compression (0 to leave as they are)
+	Quality int
+
+	// StripMetadata re-encodes images without their EXIF and other metadata
+	StripMetadata bool
+
+	// Dimensions adds the intrinsic width and height of images to the <img>
+	// tags that reference them
+	Dimensions bool
+
+	// SrcsetWidths are the widths of downscaled variants of each image to
+	// upload and offer to browsers via srcset, along with lazy loading
+	SrcsetWidths []int
+
+	// Sizes is the sizes attribute accompanying srcset (default the image's
+	// width, or the viewport width if narrower)
+	Sizes string
+
+	// InlineSVGMaxSize inlines sanitised SVGs of up to this many bytes into
+	// the documents that reference them, instead of uploading them (0 never)
+	InlineSVGMaxSize int
+}
+
+func (o ImageOptions) processing() bool {
+	return o.MaxWidth > 0 || o.Quality > 0 || o.StripMetadata
+}
+
+// processImage applies options to encoded image content, returning the
+// resulting content and its dimensions. Content that isn't a PNG or JPEG is
+// returned as is, with zero dimensions if they can't be determined.
+func processImage(content []byte, options ImageOptions) ([]byte, int, int, error) {
+	format, orientation, width, height, err := imageInfo(bytes.NewReader(content), content)
+	if err != nil {
+		return content, 0, 0, nil
+	}
+
+	if !options.processing() || (format != "png" && format != "jpeg") {
+		return content, width, height, nil
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	// Re-encoding discards EXIF, so apply its orientation to the pixels
+	decoded = orient(decoded, orientation)
+
+	resized := false
+	if options.MaxWidth > 0 && width > options.MaxWidth {
+		scaled := image.NewRGBA(image.Rect(0, 0, options.MaxWidth, height*options.MaxWidth/width))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), decoded, decoded.Bounds(), draw.Src, nil)
+		decoded, resized = scaled, true
+	}
+
+	encoded, err := encode(decoded, format, options)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	// Recompressing an already well optimised image can make it bigger
+	if !resized && !options.StripMetadata && orientation == 1 && len(encoded) >= len(content) {
+		return content, width, height, nil
+	}
+
+	bounds := decoded.Bounds()
+	return encoded, bounds.Dx(), bounds.Dy(), nil
+}
+
+// imageInfo returns the format, EXIF orientation and displayed dimensions of
+// an image read from r, given at least its leading metadata in head
+func imageInfo(r io.Reader, head []byte) (string, int, int, int, error) {
+	config, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return "", 0, 0, 0, err
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = jpegOrientation(head)
+	}
+	width, height := config.Width, config.Height
+	if orientation >= 5 {
+		// Displayed rotated by a quarter turn
+		width, height = height, width
+	}
+	return format, orientation, width, height, nil
+}
+
+// variant is an encoded, downscaled copy of an image
+type variant struct {
+	content       []byte
+	width, height int
+}
+
+// variants returns copies of a PNG or JPEG scaled down to each of
+// options.SrcsetWidths narrower than it
+func variants(content []byte, options ImageOptions) ([]variant, error) {
+	decoded, format, err := image.Decode(bytes.NewReader(content))
+	if err != nil || (format != "png" && format != "jpeg") {
+		return nil, nil
+	}
+	if format == "jpeg" {
+		decoded = orient(decoded, jpegOrientation(content))
+	}
+
+	bounds := decoded.Bounds()
+	var scaled []variant
+	for _, width := range options.SrcsetWidths {
+		if width <= 0 || width >= bounds.Dx() {
+			continue
+		}
+		height := bounds.Dy() * width / bounds.Dx()
+		resized := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(resized, resized.Bounds(), decoded, bounds, draw.Src, nil)
+
+		encoded, err := encode(resized, format, options)
+		if err != nil {
+			return nil, err
+		}
+		scaled = append(scaled, variant{encoded, width, height})
+	}
+	return scaled, nil
+}
+
+func encode(decoded image.Image, format string, options ImageOptions) ([]byte, error) {
+	var encoded bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		quality := options.Quality
+		if quality == 0 {
+			quality = jpegQuality
+		}
+		err = jpeg.Encode(&encoded, decoded, &jpeg.Options{Quality: quality})
+	case "png":
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&encoded, decoded)
+	}
+	return encoded.Bytes(), err
+}
+
+// jpegOrientation returns the EXIF orientation (1-8) of a JPEG, or 1 if it
+// doesn't have one
+func jpegOrientation(content []byte) int {
+	if len(content) < 4 || content[0] != 0xFF || content[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(content) && content[i] == 0xFF; {
+		marker := content[i+1]
+		length := int(binary.BigEndian.Uint16(content[i+2:]))
+		if marker == 0xDA {
+			// Start of scan; metadata segments all precede it
+			break
+		}
+		if length < 2 || i+2+length > len(content) {
+			// The length includes its own two bytes, so this is corrupt
+			break
+		}
+		segment := content[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first IFD of EXIF data
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// orient transforms an image as described by an EXIF orientation, so that it
+// displays correctly without it
+func orient(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dstBounds := image.Rect(0, 0, w, h)
+	if orientation >= 5 {
+		dstBounds = image.Rect(0, 0, h, w)
+	}
+	dst := image.NewRGBA(dstBounds)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // Rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // Transposed
+				dx, dy = y, x
+			case 6: // Rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // Transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // Rotated 90° anticlockwise
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, src.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,103 @@
+package wordepress
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// exifJPEG returns the start of a big-endian JPEG whose EXIF gives an
+// orientation
+func exifJPEG(orientation byte) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // header, first IFD at 8
+		0x00, 0x01, // one entry
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, orientation, 0x00, 0x00,
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(segment) + 2
+	content := []byte{0xFF, 0xD8, 0xFF, 0xE1, byte(length >> 8), byte(length)}
+	return append(append(content, segment...), 0xFF, 0xDA, 0x00, 0x02)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		want    int
+	}{
+		{"exif", exifJPEG(6), 6},
+		{"out of range", exifJPEG(9), 1},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), 1},
+		{"no exif", []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x04, 'J', 'F', 0xFF, 0xDA}, 1},
+		{"zero length segment", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x00, 0xFF, 0xDA}, 1},
+		{"one byte segment", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01, 0xFF, 0xDA}, 1},
+		{"truncated segment", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x01, 0x00, 'E', 'x'}, 1},
+	}
+	for _, test := range tests {
+		if got := jpegOrientation(test.content); got != test.want {
+			t.Errorf("%s: orientation %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+// testJPEG returns a JPEG of the given dimensions encoded at quality, with
+// EXIF giving orientation unless it is zero
+func testJPEG(t *testing.T, width, height, quality int, orientation byte) []byte {
+	decoded := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range decoded.Pix {
+		decoded.Pix[i] = byte(i * 7)
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, decoded, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	content := encoded.Bytes()
+	if orientation == 0 {
+		return content
+	}
+	// Splice in the APP1 segment of exifJPEG after the start of image
+	exif := exifJPEG(orientation)
+	app1 := exif[2 : len(exif)-4]
+	return append(append(append([]byte{}, content[:2]...), app1...), content[2:]...)
+}
+
+func TestProcessImage(t *testing.T) {
+	small := testJPEG(t, 64, 32, 10, 0)
+	tests := []struct {
+		name          string
+		content       []byte
+		options       ImageOptions
+		width, height int
+		original      bool
+	}{
+		{"downscaled", []byte(testPNG(t, 800, 400)), ImageOptions{MaxWidth: 200}, 200, 100, false},
+		{"narrow enough", small, ImageOptions{MaxWidth: 200}, 64, 32, true},
+		// Re-encoding at a higher quality only makes it bigger
+		{"larger re-encoded", small, ImageOptions{Quality: 100}, 64, 32, true},
+		{"metadata stripped", testJPEG(t, 64, 32, 90, 1), ImageOptions{StripMetadata: true}, 64, 32, false},
+		{"metadata kept", testJPEG(t, 64, 32, 10, 1), ImageOptions{Quality: 100}, 64, 32, true},
+		// Re-encoding drops the orientation, so it's applied to the pixels
+		{"rotated", testJPEG(t, 64, 32, 10, 6), ImageOptions{Quality: 100}, 32, 64, false},
+	}
+	for _, test := range tests {
+		content, width, height, err := processImage(test.content, test.options)
+		if err != nil {
+			t.Fatalf("%s: processImage: %v", test.name, err)
+		}
+		if width != test.width || height != test.height {
+			t.Errorf("%s: %dx%d, want %dx%d", test.name, width, height, test.width, test.height)
+		}
+		if bytes.Equal(content, test.content) != test.original {
+			t.Errorf("%s: original kept %v, want %v", test.name, !test.original, test.original)
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(content))
+		if err != nil || config.Width != test.width || config.Height != test.height {
+			t.Errorf("%s: encoded %dx%d: %v", test.name, config.Width, config.Height, err)
+		}
+		if !test.original && bytes.Contains(content, []byte("Exif")) {
+			t.Errorf("%s: metadata left in", test.name)
+		}
+	}
+}
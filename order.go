@@ -0,0 +1,108 @@
+package wordepress
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// orderFileName is the file listing the pages of a directory in order
+const orderFileName = ".order"
+
+var numberedNameRegexp = regexp.MustCompile(`^([0-9]+)[-_](.+)$`)
+
+// numberedName splits a name such as "01-install" into its order and the rest
+func numberedName(name string) (int, string, bool) {
+	match := numberedNameRegexp.FindStringSubmatch(name)
+	if match == nil {
+		return 0, name, false
+	}
+	order, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, name, false
+	}
+	return order, match[2], true
+}
+
+// siblingOrders returns the menu order of each of the markdown files of a
+// directory, for those whose front matter doesn't give one: its position in
+// the directory's order file if there is one, else the number prefixed to its
+// name if options allow, else its position in alphabetical order
+func siblingOrders(dir string, files []string, options ParseOptions) (map[string]int, error) {
+	orders := make(map[string]int)
+
+	listed, err := readOrderFile(filepath.Join(dir, orderFileName))
+	if err != nil {
+		return nil, err
+	}
+	if listed != nil {
+		byName := make(map[string]string)
+		for _, file := range files {
+			byName[filepath.Base(file)] = file
+		}
+		for i, name := range listed {
+			file, ok := byName[name]
+			if !ok {
+				return nil, fmt.Errorf("%s lists %s, which doesn't exist", filepath.Join(dir, orderFileName), name)
+			}
+			if _, ok := orders[file]; ok {
+				return nil, fmt.Errorf("%s lists %s more than once", filepath.Join(dir, orderFileName), name)
+			}
+			orders[file] = i + 1
+		}
+
+		// Unlisted files follow the others
+		next := len(listed) + 1
+		for _, file := range files {
+			if _, ok := orders[file]; !ok {
+				log.Printf("%s isn't listed in %s, so follows the pages that are", file, filepath.Join(dir, orderFileName))
+				orders[file] = next
+				next++
+			}
+		}
+		return orders, nil
+	}
+
+	for i, file := range files {
+		orders[file] = i + 1
+		if options.NumberedFilenames {
+			if order, _, ok := numberedName(strings.TrimSuffix(filepath.Base(file), ".md")); ok {
+				orders[file] = order
+			}
+		}
+	}
+	return orders, nil
+}
+
+// readOrderFile returns the filenames listed by an order file, one per line
+// with or without the .md extension, or nil if there's no such file. Blank
+// lines and lines starting with # are ignored.
+func readOrderFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	names := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasSuffix(line, ".md") {
+			line += ".md"
+		}
+		names = append(names, line)
+	}
+	return names, scanner.Err()
+}
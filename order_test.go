@@ -0,0 +1,97 @@
+package wordepress
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadOrderFile(t *testing.T) {
+	dir := t.TempDir()
+	names, err := readOrderFile(filepath.Join(dir, orderFileName))
+	if err != nil || names != nil {
+		t.Fatalf("missing order file: %v, %v", names, err)
+	}
+
+	writeSite(t, dir, map[string]string{
+		orderFileName: "# Getting started\r\ninstall\n\n  configure.md  \n#  usage\nfaq\n"})
+	names, err = readOrderFile(filepath.Join(dir, orderFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"install.md", "configure.md", "faq.md"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %q, want %q", names, want)
+	}
+
+	// An empty order file lists nothing, rather than being taken as absent
+	writeSite(t, dir, map[string]string{orderFileName: "# Nothing yet\n"})
+	names, err = readOrderFile(filepath.Join(dir, orderFileName))
+	if err != nil || names == nil || len(names) != 0 {
+		t.Errorf("empty order file: %#v, %v", names, err)
+	}
+}
+
+func TestSiblingOrders(t *testing.T) {
+	tests := []struct {
+		name     string
+		order    string
+		numbered bool
+		want     []int
+		err      string
+	}{
+		{"alphabetical", "", false, []int{1, 2, 3}, ""},
+		{"numbered", "", true, []int{1, 20, 3}, ""},
+		{"order file", "c\n20-b.md\n", true, []int{3, 2, 1}, ""},
+		{"missing", "c\nd\n", false, nil, "lists d.md, which doesn't exist"},
+		{"repeated", "c\nc.md\n", false, nil, "lists c.md more than once"},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		files := []string{filepath.Join(dir, "01-a.md"), filepath.Join(dir, "20-b.md"), filepath.Join(dir, "c.md")}
+		if test.order != "" {
+			writeSite(t, dir, map[string]string{orderFileName: test.order})
+		}
+
+		orders, err := siblingOrders(dir, files, ParseOptions{NumberedFilenames: test.numbered})
+		if test.err != "" {
+			if err == nil || !strings.HasSuffix(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want %s", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		var got []int
+		for _, file := range files {
+			got = append(got, orders[file])
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: orders %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNumberedName(t *testing.T) {
+	tests := []struct {
+		name  string
+		order int
+		rest  string
+		ok    bool
+	}{
+		{"01-install", 1, "install", true},
+		{"10_faq", 10, "faq", true},
+		{"2019-release-notes", 2019, "release-notes", true},
+		{"install", 0, "install", false},
+		{"01-", 0, "01-", false},
+		{"v2-upgrade", 0, "v2-upgrade", false},
+	}
+	for _, test := range tests {
+		order, rest, ok := numberedName(test.name)
+		if order != test.order || rest != test.rest || ok != test.ok {
+			t.Errorf("%s: got %d, %q, %v", test.name, order, rest, ok)
+		}
+	}
+}
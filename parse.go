@@ -1,154 +1,285 @@
 package wordepress
 
 import (
-	"bufio"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	stdpath "path"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
 
-var AttributeRegexp = regexp.MustCompile(`^([[:word:]]+):[[:space:]]*(.+?)[[:space:]]*$`)
+// ParseOptions controls the conversion of a site into documents
+type ParseOptions struct {
+	Images ImageOptions
+	Assets AssetOptions
 
-func parseReader(reader io.Reader) (map[string]string, []byte, error) {
-	scanner := bufio.NewScanner(reader)
+	// Meta declares the custom fields allowed in front matter
+	Meta []MetaField
 
-	if !scanner.Scan() || scanner.Text() != "---" {
-		return nil, nil, fmt.Errorf("missing delimiter parsing header")
-	}
-
-	attributes := make(map[string]string)
-	for {
-		if !scanner.Scan() {
-			return nil, nil, fmt.Errorf("unexpected EOF parsing header")
-		}
-		if scanner.Text() == "---" {
-			break
-		}
-		matches := AttributeRegexp.FindStringSubmatch(scanner.Text())
-		if matches == nil {
-			return nil, nil, fmt.Errorf("unable to parse header attribute: %v", scanner.Text())
-		}
-		attributes[matches[1]] = matches[2]
-	}
+	// NumberedFilenames takes the menu order of pages without one from a
+	// numeric prefix to their filename (e.g. 01-install.md), which is dropped
+	// from their slug
+	NumberedFilenames bool
 
-	var body []byte
-	for scanner.Scan() {
-		body = append(body, scanner.Bytes()...)
-		body = append(body, '\n')
-	}
-
-	return attributes, body, nil
+	// root is the directory of the site being parsed, outside which no file
+	// is uploaded
+	root string
 }
 
-func validateAttributes(attributes map[string]string) (string, int, error) {
-	title := attributes["title"]
-	if title == "" {
-		return "", 0, fmt.Errorf("missing or empty title attribute")
+// parseFile parses a markdown file into a document, with the given menu order
+// unless its front matter sets one. The title and slug given by its entry in
+// the site's navigation, if any, override its own.
+func parseFile(product, version, tag, path string, order int, entry *navEntry, parent *Document, options ParseOptions, cache *imageCache) (*Document, error) {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error open path: %v", err)
 	}
-	delete(attributes, "title")
 
-	menuOrder, err := strconv.Atoi(attributes["menu_order"])
+	frontMatter, markdown, err := parseFrontMatter(path, source, options.Meta)
 	if err != nil {
-		return "", 0, fmt.Errorf(`invalid menu_order: "%s"`, attributes["menu_order"])
+		return nil, err
 	}
-	delete(attributes, "menu_order")
 
-	if len(attributes) > 0 {
-		return "", 0, fmt.Errorf("unknown attributes: %v", attributes)
+	title := frontMatter.Title
+	if entry != nil && entry.Title != "" {
+		title = entry.Title
 	}
-
-	return title, menuOrder, nil
-}
-
-func parseFile(product, version, tag, path string, parent *Document) (*Document, []*Image, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, nil, fmt.Errorf("error open path: %v", err)
+	if title == "" {
+		p := frontMatter.positions["title"]
+		return nil, &FrontMatterError{path, p.line, p.column, "missing or empty title"}
 	}
-	defer file.Close()
 
-	attributes, markdown, err := parseReader(file)
+	content, images, err := rewrite(product, version, tag, stdpath.Dir(path), markdown, options, cache)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	title, menuOrder, err := validateAttributes(attributes)
+	base := strings.TrimSuffix(stdpath.Base(path), ".md")
+	if options.NumberedFilenames {
+		_, base, _ = numberedName(base)
+	}
+	if frontMatter.Slug != "" {
+		base = frontMatter.Slug
+	}
+	if entry != nil && entry.Slug != "" {
+		base = entry.Slug
+	}
+	slug, err := sanitiseSlug(qualifySlug(product, tag, base))
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	content, images, err := rewrite(product, version, tag, stdpath.Dir(path), markdown)
-	if err != nil {
-		return nil, nil, err
+	document := &Document{
+		LocalParent:   parent,
+		Title:         Text{Raw: title},
+		MenuOrder:     frontMatter.MenuOrder,
+		Product:       product,
+		Version:       version,
+		Name:          base,
+		Tag:           tag,
+		Slug:          slug,
+		Content:       Text{Raw: string(content)},
+		Excerpt:       Text{Raw: frontMatter.Excerpt},
+		Images:        images,
+		Status:        "publish",
+		CommentStatus: frontMatter.CommentStatus,
+		Template:      frontMatter.Template,
+		CategoryNames: frontMatter.Categories,
+		TagNames:      frontMatter.Tags,
+		Meta:          frontMatter.meta,
+		sitePath:      strings.TrimSuffix(path, ".md")}
+
+	if _, ok := frontMatter.positions["menu_order"]; !ok {
+		document.MenuOrder = order
+	}
+	if frontMatter.Status != "" {
+		document.Status = frontMatter.Status
+	}
+	if id, err := strconv.Atoi(frontMatter.Author); err == nil {
+		document.Author = id
+	} else {
+		document.AuthorSlug = frontMatter.Author
+	}
+	if !frontMatter.Date.IsZero() {
+		document.Date = frontMatter.Date.UTC().Format("2006-01-02T15:04:05")
 	}
 
-	base := strings.TrimSuffix(stdpath.Base(path), ".md")
-	slug, err := sanitiseSlug(qualifySlug(product, tag, base))
-	if err != nil {
-		return nil, nil, err
+	if frontMatter.FeaturedImage != "" {
+		filename := stdpath.Join(stdpath.Dir(path), frontMatter.FeaturedImage)
+		if !insideSite(options.root, filename) {
+			p := frontMatter.positions["featured_image"]
+			return nil, &FrontMatterError{path, p.line, p.column, fmt.Sprintf("featured image %s is outside the site", frontMatter.FeaturedImage)}
+		}
+		featured, err := cache.read(filename, func(filename string) (*Image, error) {
+			return ReadImage(filename, options.Images)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("featured image: %v", err)
+		}
+		cache.reference(featured)
+		document.FeaturedImage = featured
+		document.Images = append(document.Images, featured)
 	}
 
-	return &Document{
-		LocalParent: parent,
-		Title:       Text{Raw: title},
-		MenuOrder:   menuOrder,
-		Product:     product,
-		Version:     version,
-		Name:        base,
-		Tag:		 tag,
-		Slug:        slug,
-		Content:     Text{Raw: string(content)},
-		Status:      "publish"}, images, nil
+	return document, nil
 }
 
-func recursiveParseSite(product, version, tag, path string, parent *Document) ([]*Document, []*Image, error) {
+func recursiveParseSite(product, version, tag, path string, parent *Document, options ParseOptions, cache *imageCache) ([]*Document, error) {
 	fileInfo, err := os.Stat(path)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	if !fileInfo.IsDir() {
-		return nil, nil, fmt.Errorf("path %v is not a directory", path)
+		return nil, fmt.Errorf("path %v is not a directory", path)
 	}
 
 	glob := fmt.Sprintf("%s/*.md", path)
 	files, err := filepath.Glob(glob)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	log.Printf("Loading %d markdown files from %s", len(files), path)
 
-	var documents []*Document
-	var siteImages []*Image
+	orders, err := siblingOrders(path, files, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var siblings []*Document
 	for _, file := range files {
-		document, images, err := parseFile(product, version, tag, file, parent)
+		document, err := parseFile(product, version, tag, file, orders[file], nil, parent, options, cache)
+		if _, ok := err.(*FrontMatterError); ok {
+			// Already locates the problem
+			return nil, err
+		}
 		if err != nil {
-			return nil, nil, fmt.Errorf("parse %v: %v", file, err)
+			return nil, fmt.Errorf("parse %v: %v", file, err)
 		}
+		siblings = append(siblings, document)
+	}
+
+	// Order siblings as they appear in the navigation, ties remaining in
+	// alphabetical order
+	sort.SliceStable(siblings, func(i, j int) bool { return siblings[i].MenuOrder < siblings[j].MenuOrder })
+	for i := 1; i < len(siblings); i++ {
+		if siblings[i].MenuOrder == siblings[i-1].MenuOrder {
+			log.Printf("%s.md and %s.md have the same menu order %d, so may appear in either order",
+				siblings[i-1].sitePath, siblings[i].sitePath, siblings[i].MenuOrder)
+		}
+	}
 
+	var documents []*Document
+	for _, document := range siblings {
 		documents = append(documents, document)
-		siteImages = append(siteImages, images...)
 
-		childPath := strings.TrimSuffix(file, ".md")
+		childPath := document.sitePath
 		if _, err := os.Stat(childPath); err == nil {
-			children, images, err := recursiveParseSite(product, version, tag, childPath, document)
+			children, err := recursiveParseSite(product, version, tag, childPath, document, options, cache)
 			if err != nil {
-				return nil, nil, err
+				return nil, err
 			}
 			documents = append(documents, children...)
-			siteImages = append(siteImages, images...)
 		}
 	}
 
-	return documents, siteImages, nil
+	return documents, nil
+}
+
+// ParseSite parses the documents of a site, along with every distinct image
+// and linked file they reference
+func ParseSite(product, version, tag, path string, options ParseOptions) ([]*Document, []*Image, error) {
+	options.root = path
+	cache := newImageCache()
+	nav, err := readNav(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var documents []*Document
+	if nav != nil {
+		documents, err = parseNav(product, version, tag, path, nav, nil, options, cache, make(map[string]bool))
+	} else {
+		documents, err = recursiveParseSite(product, version, tag, path, nil, options, cache)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = relink(product, tag, path, documents)
+	if err != nil {
+		return nil, nil, err
+	}
+	return documents, cache.images, nil
+}
+
+// documentPath returns the path of a document's URL within its site
+func documentPath(document *Document) string {
+	if document.LocalParent == nil {
+		return document.Name
+	}
+	return documentPath(document.LocalParent) + "/" + document.Name
+}
+
+// documentSource describes where a document comes from, for errors
+func documentSource(document *Document) string {
+	if document.Link != "" {
+		return "the link to " + document.Link
+	}
+	if document.LocalParent != nil {
+		return fmt.Sprintf("%s.md (under %s)", document.sitePath, document.LocalParent.Slug)
+	}
+	return document.sitePath + ".md"
 }
 
-func ParseSite(product, version, tag, path string) ([]*Document, []*Image, error) {
-	return recursiveParseSite(product, version, tag, path, nil)
+// relink points links between documents at the URLs of documents whose slug
+// was overridden, as they're rewritten from the filenames linked to
+func relink(product, tag, root string, documents []*Document) error {
+	prefix := fmt.Sprintf(`<a href="/docs/%s/%s/`, product, tag)
+	slugs := make(map[string]*Document)
+	var renames [][2]string
+	renamed := make(map[string]bool)
+	for _, document := range documents {
+		if other, ok := slugs[document.Slug]; ok {
+			if other.sitePath == document.sitePath && document.sitePath != "" {
+				return fmt.Errorf("%s.md is listed twice by the navigation with the slug %s: give one a slug", document.sitePath, document.Slug)
+			}
+			return fmt.Errorf("%s and %s both have the slug %s", documentSource(other), documentSource(document), document.Slug)
+		}
+		slugs[document.Slug] = document
+
+		// Links to a page listed more than once by the navigation go to
+		// the first, and links in the navigation have no file to link to
+		if document.sitePath == "" || renamed[document.sitePath] {
+			continue
+		}
+		renamed[document.sitePath] = true
+
+		from, err := filepath.Rel(root, document.sitePath)
+		if err != nil {
+			return err
+		}
+		from, to := filepath.ToSlash(from), documentPath(document)
+		if from != to {
+			renames = append(renames, [2]string{prefix + from + "/", prefix + to + "/"})
+		}
+	}
+	if len(renames) == 0 {
+		return nil
+	}
+
+	// Replace a subpage's link before its parent's, which is a prefix of it
+	sort.Slice(renames, func(i, j int) bool { return len(renames[i][0]) > len(renames[j][0]) })
+	var pairs []string
+	for _, rename := range renames {
+		pairs = append(pairs, rename[0], rename[1])
+	}
+	replacer := strings.NewReplacer(pairs...)
+	for _, document := range documents {
+		document.Content.Raw = replacer.Replace(document.Content.Raw)
+	}
+	return nil
 }
@@ -0,0 +1,128 @@
+package wordepress
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeSite writes the files of a site, keyed by their path relative to dir
+func writeSite(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParseSiteAssetOutsideSite(t *testing.T) {
+	dir := t.TempDir()
+	writeSite(t, dir, map[string]string{
+		"secret.pdf":     "not for publishing",
+		"site/guide.pdf": "guide",
+		"site/index.md":  "---\ntitle: Index\n---\n[Guide](guide.pdf)\n",
+	})
+	options := ParseOptions{Assets: AssetOptions{Extensions: []string{"pdf"}}}
+
+	_, images, err := ParseSite("scope", "1.0", "v1.0", filepath.Join(dir, "site"), options)
+	if err != nil {
+		t.Fatalf("ParseSite: %v", err)
+	}
+	if len(images) != 1 {
+		t.Fatalf("%d files to upload, want the guide", len(images))
+	}
+
+	writeSite(t, dir, map[string]string{
+		"site/index.md": "---\ntitle: Index\n---\n[Secret](../secret.pdf)\n",
+	})
+	_, _, err = ParseSite("scope", "1.0", "v1.0", filepath.Join(dir, "site"), options)
+	if err == nil || !strings.Contains(err.Error(), "outside the site") {
+		t.Fatalf("got %v, want a link outside the site refused", err)
+	}
+}
+
+func TestParseSiteFeaturedImageOutsideSite(t *testing.T) {
+	dir := t.TempDir()
+	writeSite(t, dir, map[string]string{
+		"banner.png":    "not for publishing",
+		"site/index.md": "---\ntitle: Index\nfeatured_image: ../banner.png\n---\nHello\n",
+	})
+
+	_, _, err := ParseSite("scope", "1.0", "v1.0", filepath.Join(dir, "site"), ParseOptions{})
+	want := filepath.Join(dir, "site", "index.md") + ":3:1: featured image ../banner.png is outside the site"
+	if err == nil || err.Error() != want {
+		t.Fatalf("got %v, want %s", err, want)
+	}
+}
+
+func TestParseSiteSharedImages(t *testing.T) {
+	dir := t.TempDir()
+	content := testPNG(t, 40, 20)
+	writeSite(t, dir, map[string]string{
+		"site/index.md":           "---\ntitle: Index\n---\n![Diagram](images/diagram.png)\n",
+		"site/install.md":         "---\ntitle: Install\n---\n![Diagram](images/diagram.png)\n![Copy](images/copy.png)\n",
+		"site/images/diagram.png": content,
+		"site/images/copy.png":    content,
+	})
+
+	documents, images, err := ParseSite("scope", "1.0", "v1.0", filepath.Join(dir, "site"), ParseOptions{})
+	if err != nil {
+		t.Fatalf("ParseSite: %v", err)
+	}
+	if len(images) != 1 {
+		t.Fatalf("%d images to upload, want the one file", len(images))
+	}
+	for _, document := range documents {
+		for _, image := range document.Images {
+			if image != images[0] {
+				t.Errorf("%s references a second copy of %s", document.Slug, image.Filename)
+			}
+		}
+	}
+
+	// Unprocessed, the image is uploaded straight from the file
+	image := images[0]
+	if image.Content != nil || image.Size != int64(len(content)) || image.Width != 40 {
+		t.Errorf("image held in memory, or of size %d and width %d", image.Size, image.Width)
+	}
+	reader, err := image.Open()
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer reader.Close()
+	if streamed, err := ioutil.ReadAll(reader); err != nil || string(streamed) != content {
+		t.Errorf("streamed %d bytes, want the file: %v", len(streamed), err)
+	}
+}
+
+func TestImageCacheReadsOnce(t *testing.T) {
+	cache := newImageCache()
+	reads := 0
+	read := func(filename string) (*Image, error) {
+		reads++
+		return newImage(filename, []byte("content"), 0, 0), nil
+	}
+
+	first, _ := cache.read("images/diagram.png", read)
+	again, _ := cache.read("images/diagram.png", read)
+	renamed, _ := cache.read("images/copy.png", read)
+	if reads != 2 {
+		t.Errorf("%d reads, want one per path", reads)
+	}
+	if again != first || renamed != first {
+		t.Errorf("identical files given distinct images")
+	}
+
+	for _, image := range []*Image{first, again, renamed} {
+		cache.reference(image)
+	}
+	if len(cache.images) != 1 {
+		t.Errorf("%d images listed for upload, want 1", len(cache.images))
+	}
+}
@@ -0,0 +1,72 @@
+package wordepress
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// PluginVersion is the version of the Wordepress plugin that provides
+// everything wordepress relies on
+const PluginVersion = "1.8.0"
+
+// PostTypes returns the REST bases of the post types the Wordepress plugin
+// registers its fields for (see its wordepress_post_types filter), or nil if
+// the plugin predates listing them
+func (c *Client) PostTypes(ctx context.Context) ([]string, error) {
+	var types []struct {
+		RestBase string `json:"rest_base"`
+	}
+	found, err := c.getJSON(ctx, c.BaseURL+"/wp-json/wordepress/v1/post-types", &types)
+	if err != nil || !found {
+		return nil, err
+	}
+	restBases := []string{}
+	for _, postType := range types {
+		restBases = append(restBases, postType.RestBase)
+	}
+	return restBases, nil
+}
+
+// SchemaFields returns the names of the fields WordPress describes in the
+// schema of the documents endpoint, with meta keys as "meta.key". Fields
+// registered without a schema, as by versions of the Wordepress plugin before
+// 1.8.0, are missing.
+func (c *Client) SchemaFields(ctx context.Context) (map[string]bool, error) {
+	request, err := c.newRequest(ctx, "OPTIONS", c.DocumentsEndpoint(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, responseBytes, err := c.do(request, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, newAPIError(response, responseBytes)
+	}
+
+	var schema struct {
+		Schema struct {
+			Properties map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"properties"`
+		} `json:"schema"`
+	}
+	err = json.Unmarshal(responseBytes, &schema)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]bool)
+	for name, property := range schema.Schema.Properties {
+		fields[name] = true
+		if name == "meta" {
+			for key := range property.Properties {
+				fields[metaPrefix+key] = true
+			}
+		}
+	}
+	return fields, nil
+}
@@ -2,7 +2,7 @@
 /*
 Plugin Name: Weaveworks Wordepress
 Description: Host technical documentation in WordPress
-Version: 1.1.0
+Version: 1.8.0
 Author: Adam Harrison
 */
 
@@ -13,12 +13,30 @@ function wordepress_init_post_type( $post_type_name ) {
         $wp_post_types[$post_type_name]->show_in_rest = true;
         $wp_post_types[$post_type_name]->rest_base = $post_type_name;
         $wp_post_types[$post_type_name]->rest_controller_class = 'WP_REST_Posts_Controller';
+
+        // Revisions identify who last edited a document, when wordepress
+        // finds it has been modified since it was published
+        add_post_type_support( $post_type_name, 'revisions' );
+
+        // The REST API only accepts the excerpt, author, comment status and
+        // featured image of post types supporting them, which front matter
+        // may set along with categories and tags
+        add_post_type_support( $post_type_name, array( 'excerpt', 'author', 'comments', 'thumbnail' ) );
+        register_taxonomy_for_object_type( 'category', $post_type_name );
+        register_taxonomy_for_object_type( 'post_tag', $post_type_name );
     }
 }
 
 // Register the `document` post type with the REST API
 add_action( 'init', function () { wordepress_init_post_type ( 'documentation' ); }, 100);
 
+// The post types published into, which get the checksum and link fields
+// wordepress relies on. Sites publishing into others add them with e.g.
+// add_filter( 'wordepress_post_types', function ( $types ) { return array_merge( $types, array( 'page' ) ); } );
+function wordepress_post_types() {
+    return apply_filters( 'wordepress_post_types', array( 'documentation' ) );
+}
+
 add_action( 'rest_api_init', function () {
     register_rest_field( 'documentation',
         'wpcf-product',
@@ -52,8 +70,60 @@ add_action( 'rest_api_init', function () {
             'schema'          => null,
         )
     );
+    // Unlike the fields above these have a schema, which wordepress checks
+    // for before publishing anything
+    register_rest_field( wordepress_post_types(),
+        'wordepress-checksum',
+        array(
+            'get_callback'    => 'wordepress_get_meta',
+            'update_callback' => 'wordepress_update_checksum',
+            'schema'          => array(
+                'description' => 'When the document was last saved by wordepress',
+                'type'        => 'string',
+                'context'     => array( 'view', 'edit' ),
+            ),
+        )
+    );
+    register_rest_field( wordepress_post_types(),
+        'wordepress-link',
+        array(
+            'get_callback'    => 'wordepress_get_meta',
+            'update_callback' => 'wordepress_update_link',
+            'schema'          => array(
+                'description' => 'URL the document stands in for a link to',
+                'type'        => 'string',
+                'context'     => array( 'view', 'edit' ),
+            ),
+        )
+    );
 });
 
+// Lists the post types above, so that `media gc` can find every document that
+// might use an image before deleting it
+add_action( 'rest_api_init', function () {
+    register_rest_route( 'wordepress/v1', '/post-types', array(
+        'methods'             => 'GET',
+        'callback'            => 'wordepress_get_post_types',
+        'permission_callback' => function () {
+            return current_user_can( 'edit_posts' );
+        },
+    ) );
+});
+
+function wordepress_get_post_types() {
+    $types = array();
+    foreach ( wordepress_post_types() as $name ) {
+        $type = get_post_type_object( $name );
+        if ( $type && $type->show_in_rest ) {
+            $types[] = array(
+                'name'      => $name,
+                'rest_base' => $type->rest_base ? $type->rest_base : $name,
+            );
+        }
+    }
+    return $types;
+}
+
 function wordepress_get_meta( $object, $field_name, $request ) {
     return get_post_meta( $object[ 'id' ], $field_name, true );
 }
@@ -66,6 +136,58 @@ function wordepress_update_meta( $value, $object, $field_name ) {
     return update_post_meta( $object->ID, $field_name, strip_tags( $value ) );
 }
 
+// Rather than the value sent, record when the document was saved, which
+// changes should anyone else edit it. Fields are updated once the post itself
+// has been, and updating meta leaves its modification time alone.
+function wordepress_update_checksum( $value, $object, $field_name ) {
+    if ( ! $value || ! is_string( $value ) ) {
+        return;
+    }
+
+    return update_post_meta( $object->ID, $field_name, mysql_to_rfc3339( $object->post_modified_gmt ) );
+}
+
+// Unlike the other fields, the link is cleared when a page of the navigation
+// no longer stands in for a link
+function wordepress_update_link( $value, $object, $field_name ) {
+    if ( ! is_string( $value ) ) {
+        return;
+    }
+    if ( $value === '' ) {
+        return delete_post_meta( $object->ID, $field_name );
+    }
+
+    return update_post_meta( $object->ID, $field_name, esc_url_raw( $value ) );
+}
+
+// Navigation built from the page hierarchy leads straight to the targets of
+// pages standing in for links, and anyone arriving at one is sent on
+function wordepress_post_link( $url, $post ) {
+    $post = get_post( $post );
+    if ( $post && in_array( $post->post_type, wordepress_post_types(), true ) ) {
+        $link = get_post_meta( $post->ID, 'wordepress-link', true );
+        if ( $link ) {
+            return $link;
+        }
+    }
+    return $url;
+}
+
+// Posts and pages have filters of their own, the latter passing only the ID
+add_filter( 'post_type_link', 'wordepress_post_link', 10, 2 );
+add_filter( 'post_link', 'wordepress_post_link', 10, 2 );
+add_filter( 'page_link', 'wordepress_post_link', 10, 2 );
+
+add_action( 'template_redirect', function () {
+    if ( is_singular( wordepress_post_types() ) ) {
+        $link = get_post_meta( get_queried_object_id(), 'wordepress-link', true );
+        if ( $link ) {
+            wp_redirect( $link );
+            exit;
+        }
+    }
+});
+
 add_filter( 'theme_documentation_templates', function ( $post_templates ) {
 
     // When we POST a new document via wordepress, we do not specify a value
@@ -76,11 +198,46 @@ add_filter( 'theme_documentation_templates', function ( $post_templates ) {
     // determine the valid set of templates does not work with our template
     // files, and so this validation fails. Work around this by installing a
     // filter that forcibly adds 'single.php' to the list of valid templates
-    // for the documentation CPT.
+    // for the documentation CPT, keeping any templates that were found for
+    // documents that choose one in their front matter.
+
+    return array_merge( $post_templates, array('single.php' => 'single.php') );
+});
 
-    return array('single.php' => 'single.php');
+add_filter( 'upload_mimes', function ( $mimes ) {
+
+    // WordPress doesn't accept SVG uploads as they can contain script.
+    // wordepress sanitises SVGs before uploading them, so allow them for
+    // users that are trusted with unfiltered HTML anyway, along with the
+    // YAML and JSON files that documentation links to as examples.
+
+    if ( current_user_can( 'unfiltered_html' ) ) {
+        $mimes['svg'] = 'image/svg+xml';
+        $mimes['yaml|yml'] = 'text/yaml';
+        $mimes['json'] = 'application/json';
+    }
+    return $mimes;
 });
 
+add_filter( 'wp_check_filetype_and_ext', function ( $data, $file, $filename, $mimes ) {
+
+    // fileinfo reports these as text/xml or text/plain, so the extension
+    // check rejects them even once the type is allowed
+
+    $types = array(
+        'svg'  => 'image/svg+xml',
+        'yaml' => 'text/yaml',
+        'yml'  => 'text/yaml',
+        'json' => 'application/json',
+    );
+    $ext = strtolower( pathinfo( $filename, PATHINFO_EXTENSION ) );
+    if ( current_user_can( 'unfiltered_html' ) && isset( $types[ $ext ] ) ) {
+        $data['ext'] = $ext;
+        $data['type'] = $types[ $ext ];
+    }
+    return $data;
+}, 10, 4 );
+
 add_filter( 'query_vars', function ( $valid_vars ) {
     $valid_vars = array_merge( $valid_vars, array( 'meta_query' ) );
     return $valid_vars;
@@ -0,0 +1,190 @@
+package wordepress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts made for each request,
+	// including the first; values below one are treated as one
+	MaxAttempts int
+
+	// MinBackoff and MaxBackoff bound the delay before each retry. MaxBackoff
+	// also caps the delay a server asks for with Retry-After.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	MinBackoff:  500 * time.Millisecond,
+	MaxBackoff:  30 * time.Second}
+
+// errApplied is returned by do when a failed non-idempotent request is
+// found to have taken effect on the server after all
+var errApplied = errors.New("request already applied")
+
+// probeFunc is consulted before retrying a non-idempotent request whose
+// outcome is unknown (e.g. the connection dropped after the request was sent)
+// and reports whether the request took effect regardless
+type probeFunc func(ctx context.Context) (bool, error)
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+// rejected reports whether the server refused to process the request at all,
+// in which case it is safe to retry even when it isn't idempotent
+func rejected(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+func transient(status int) bool {
+	return rejected(status) || status == http.StatusBadGateway || status == http.StatusGatewayTimeout
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.MinBackoff << uint(attempt)
+	if ceiling <= 0 || ceiling > p.MaxBackoff {
+		ceiling = p.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	// Full jitter, so that concurrent clients don't retry in lockstep
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+func retryAfter(response *http.Response) (time.Duration, bool) {
+	value := response.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		return time.Until(when), true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// do executes the request according to the client's retry policy and rate
+// limit, returning the final response together with its fully read body.
+// Non-idempotent requests are only retried when the server rejected them
+// outright, or when probe confirms that the failed attempt had no effect.
+func (c *Client) do(request *http.Request, probe probeFunc) (*http.Response, []byte, error) {
+	ctx := request.Context()
+	attempts := c.Retry.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	reauthenticated := false
+	for attempt := 0; ; {
+		if c.Limiter != nil {
+			if err := c.Limiter.Wait(ctx); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if (attempt > 0 || reauthenticated) && request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, nil, err
+			}
+			request.Body = body
+		}
+
+		if c.Auth != nil {
+			if err := c.Auth.Authenticate(ctx, request); err != nil {
+				return nil, nil, fmt.Errorf("authentication failed: %w", err)
+			}
+		}
+
+		response, responseBytes, err := sendWith(c.HTTPClient, request)
+		if ctx.Err() != nil {
+			return response, responseBytes, err
+		}
+
+		// Credentials fetched from the server (tokens, nonces) may have
+		// expired; discard them and try once more with fresh ones, which
+		// doesn't count as an attempt
+		if err == nil && response.StatusCode == http.StatusUnauthorized && !reauthenticated {
+			if r, ok := c.Auth.(resetter); ok {
+				r.Reset()
+				reauthenticated = true
+				continue
+			}
+		}
+		if attempt == attempts-1 {
+			return response, responseBytes, err
+		}
+
+		var reason string
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case transient(response.StatusCode):
+			reason = response.Status
+		default:
+			return response, responseBytes, nil
+		}
+
+		if !idempotent(request.Method) && (err != nil || !rejected(response.StatusCode)) {
+			if probe == nil {
+				return response, responseBytes, err
+			}
+			applied, probeErr := probe(ctx)
+			if probeErr != nil {
+				return response, responseBytes, err
+			}
+			if applied {
+				return nil, nil, errApplied
+			}
+		}
+
+		delay := c.Retry.backoff(attempt)
+		if response != nil {
+			if after, ok := retryAfter(response); ok {
+				// Honoured up to the longest backoff, so that a server can't
+				// stall publishing indefinitely
+				delay = after
+				if delay > c.Retry.MaxBackoff {
+					delay = c.Retry.MaxBackoff
+				}
+			}
+		}
+
+		log.Printf("Retrying %s %s in %v: %s", request.Method, request.URL.Path, delay, reason)
+		if err := sleep(ctx, delay); err != nil {
+			return nil, nil, err
+		}
+		attempt++
+	}
+}
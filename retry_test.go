@@ -0,0 +1,295 @@
+package wordepress
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/wordepress/wordepresstest"
+)
+
+// flaky fronts a fake WordPress, counting requests by method
+type flaky struct {
+	*wordepresstest.Server
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+// newFlakyClient returns a client of a flaky WordPress. Given the method and
+// number of a request, fail returns the status with which it fails (0 for
+// none) and whether it is passed on to WordPress regardless.
+func newFlakyClient(t *testing.T, fail func(method string, n int) (status int, forward bool)) (*Client, *flaky) {
+	f := &flaky{Server: wordepresstest.NewServer(), requests: make(map[string]int)}
+	t.Cleanup(f.Server.Close)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests[r.Method]++
+		n := f.requests[r.Method]
+		f.mu.Unlock()
+
+		status, forward := fail(r.Method, n)
+		if forward {
+			f.Server.Config.Handler.ServeHTTP(httptest.NewRecorder(), r)
+		}
+		if status != 0 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(status)
+			return
+		}
+		if !forward {
+			f.Server.Config.Handler.ServeHTTP(w, r)
+		}
+	}))
+	t.Cleanup(proxy.Close)
+
+	client := NewClient(proxy.URL, nil)
+	client.Retry = RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	return client, f
+}
+
+func (f *flaky) count(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[method]
+}
+
+func TestRetryTransient(t *testing.T) {
+	client, f := newFlakyClient(t, func(method string, n int) (int, bool) {
+		if method == "GET" && n < 3 {
+			return http.StatusBadGateway, false
+		}
+		return 0, false
+	})
+	if _, err := client.GetDocuments(context.Background(), "per_page=100"); err != nil {
+		t.Fatalf("GetDocuments: %v", err)
+	}
+	if n := f.count("GET"); n != 3 {
+		t.Errorf("%d attempts, want 3", n)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	client, f := newFlakyClient(t, func(method string, n int) (int, bool) {
+		return http.StatusServiceUnavailable, false
+	})
+	_, err := client.GetDocuments(context.Background(), "per_page=100")
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got %v, want the last 503", err)
+	}
+	if n := f.count("GET"); n != 3 {
+		t.Errorf("%d attempts, want 3", n)
+	}
+}
+
+func TestRetryNotFound(t *testing.T) {
+	client, f := newFlakyClient(t, func(method string, n int) (int, bool) { return 0, false })
+	if _, err := client.PutDocument(context.Background(), 42, newTestDocument("install")); ErrorCode(err) != "rest_post_invalid_id" {
+		t.Fatalf("got %v, want rest_post_invalid_id", err)
+	}
+	if n := f.count("PUT"); n != 1 {
+		t.Errorf("%d attempts at a permanent failure, want 1", n)
+	}
+}
+
+func TestRetryPostRejected(t *testing.T) {
+	// A 429 means WordPress didn't process the request, so it's retried
+	// without asking whether it took effect
+	client, f := newFlakyClient(t, func(method string, n int) (int, bool) {
+		if method == "POST" && n == 1 {
+			return http.StatusTooManyRequests, false
+		}
+		return 0, false
+	})
+	if _, err := client.PostDocument(context.Background(), newTestDocument("install")); err != nil {
+		t.Fatalf("PostDocument: %v", err)
+	}
+	if n, probes := f.count("POST"), f.count("GET"); n != 2 || probes != 0 {
+		t.Errorf("%d attempts and %d probes, want 2 and none", n, probes)
+	}
+}
+
+func TestRetryPostProbe(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		forward bool
+	}{
+		{"applied", true},
+		{"not applied", false},
+	} {
+		// The first POST fails with a 502, having reached WordPress or not
+		client, f := newFlakyClient(t, func(method string, n int) (int, bool) {
+			if method == "POST" && n == 1 {
+				return http.StatusBadGateway, test.forward
+			}
+			return 0, false
+		})
+		document, err := client.PostDocument(context.Background(), newTestDocument("install"))
+		if err != nil {
+			t.Fatalf("%s: PostDocument: %v", test.name, err)
+		}
+		if document.Slug != "scope-v1.0-install" || document.Product != "scope" {
+			t.Errorf("%s: PostDocument returned %+v", test.name, document)
+		}
+
+		want := 2
+		if test.forward {
+			want = 1
+		}
+		if n, probes := f.count("POST"), f.count("GET"); n != want || probes != 1 {
+			t.Errorf("%s: %d attempts and %d probes, want %d and 1", test.name, n, probes, want)
+		}
+		if posts := f.Posts(DefaultRestBase); len(posts) != 1 {
+			t.Errorf("%s: %d documents created, want 1", test.name, len(posts))
+		}
+	}
+}
+
+func TestRetryPostNoProbe(t *testing.T) {
+	// Batches may create documents but can't be probed, so aren't retried
+	client, f := newFlakyClient(t, func(method string, n int) (int, bool) {
+		if method == "POST" && n == 1 {
+			return http.StatusGatewayTimeout, false
+		}
+		return 0, false
+	})
+	err := client.Batch(context.Background(), []*BatchOperation{{Method: "POST", Document: newTestDocument("install")}})
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("got %v, want the 504", err)
+	}
+	if n := f.count("POST"); n != 1 {
+		t.Errorf("%d attempts, want 1", n)
+	}
+}
+
+// resetCounter is an authenticator counting how often it is reset
+type resetCounter struct{ resets int }
+
+func (a *resetCounter) Authenticate(ctx context.Context, request *http.Request) error { return nil }
+func (a *resetCounter) Reset()                                                        { a.resets++ }
+
+func TestRetryReauthenticate(t *testing.T) {
+	// Rejected credentials are fetched afresh once, even with no retries
+	client, f := newFlakyClient(t, func(method string, n int) (int, bool) {
+		if method == "POST" && n == 1 || method == "GET" {
+			return http.StatusUnauthorized, false
+		}
+		return 0, false
+	})
+	client.Retry.MaxAttempts = 1
+	auth := &resetCounter{}
+	client.Auth = auth
+
+	posted, err := client.PostDocument(context.Background(), newTestDocument("install"))
+	if err != nil {
+		t.Fatalf("PostDocument: %v", err)
+	}
+	if posted.Slug != "scope-v1.0-install" {
+		t.Errorf("slug %q: body not sent again", posted.Slug)
+	}
+	if n := f.count("POST"); n != 2 || auth.resets != 1 {
+		t.Errorf("%d attempts and %d resets, want 2 and 1", n, auth.resets)
+	}
+
+	_, err = client.GetDocuments(context.Background(), "per_page=100")
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got %v, want the 401", err)
+	}
+	if n := f.count("GET"); n != 2 || auth.resets != 2 {
+		t.Errorf("%d attempts and %d resets, want 2 and 2", n, auth.resets)
+	}
+}
+
+func TestRetryContextCancelled(t *testing.T) {
+	client, _ := newFlakyClient(t, func(method string, n int) (int, bool) {
+		return http.StatusServiceUnavailable, false
+	})
+	client.Retry = RetryPolicy{MaxAttempts: 5, MinBackoff: time.Hour, MaxBackoff: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	// Retry-After says to retry at once, so use a server without it
+	client.HTTPClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		response, err := http.DefaultTransport.RoundTrip(r)
+		if err == nil {
+			response.Header.Del("Retry-After")
+		}
+		return response, err
+	})
+	start := time.Now()
+	_, err := client.GetDocuments(ctx, "per_page=100")
+	if err != context.DeadlineExceeded || time.Since(start) > 10*time.Second {
+		t.Fatalf("got %v after %v, want the deadline exceeded promptly", err, time.Since(start))
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestRetryAfterClamped(t *testing.T) {
+	client, f := newFlakyClient(t, func(method string, n int) (int, bool) {
+		if n == 1 {
+			return http.StatusServiceUnavailable, false
+		}
+		return 0, false
+	})
+	client.Retry = RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	client.HTTPClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		response, err := http.DefaultTransport.RoundTrip(r)
+		if err == nil && response.StatusCode == http.StatusServiceUnavailable {
+			response.Header.Set("Retry-After", "3600")
+		}
+		return response, err
+	})
+
+	start := time.Now()
+	if _, err := client.GetDocuments(context.Background(), "per_page=100"); err != nil {
+		t.Fatalf("GetDocuments: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second || f.count("GET") != 2 {
+		t.Errorf("%d attempts in %v, want 2 without waiting the hour asked", f.count("GET"), elapsed)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, ceiling := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		for i := 0; i < 20; i++ {
+			if d := policy.backoff(attempt); d < 0 || d >= ceiling*time.Millisecond {
+				t.Fatalf("attempt %d: backoff %v, want below %v", attempt, d, ceiling*time.Millisecond)
+			}
+		}
+	}
+	if d := (RetryPolicy{}).backoff(3); d != 0 {
+		t.Errorf("zero policy backoff %v", d)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+	} {
+		response := &http.Response{Header: http.Header{}}
+		if test.header != "" {
+			response.Header.Set("Retry-After", test.header)
+		}
+		if d, ok := retryAfter(response); d != test.want || ok != test.ok {
+			t.Errorf("%q: got %v, %v", test.header, d, ok)
+		}
+	}
+
+	response := &http.Response{Header: http.Header{"Retry-After": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}}}
+	if d, ok := retryAfter(response); !ok || d < 58*time.Minute || d > time.Hour {
+		t.Errorf("HTTP date: got %v, %v", d, ok)
+	}
+}
@@ -3,6 +3,7 @@ package wordepress
 import (
 	"fmt"
 	"github.com/weaveworks/blackfriday"
+	stdhtml "html"
 	stdpath "path"
 	"regexp"
 	"strings"
@@ -10,6 +11,10 @@ import (
 
 var AnchorRegexp = regexp.MustCompile(`<a href="([^#"]*)`)
 var ImgRegexp = regexp.MustCompile(`<img src="([^"]*)"`)
+var ImgTagRegexp = regexp.MustCompile(`<img src="[^"]*"[^>]*>`)
+var imgAttributeRegexp = regexp.MustCompile(` (alt|title)="([^"]*)"`)
+var imgSizeRegexp = regexp.MustCompile(` (width|height)=`)
+var imgSrcsetRegexp = regexp.MustCompile(` (srcset|loading)=`)
 var BackslashRegexp = regexp.MustCompile(`\\`)
 
 func convertToHTML(input []byte) []byte {
@@ -25,7 +30,19 @@ func convertToHTML(input []byte) []byte {
 	return blackfriday.MarkdownOptions(input, renderer, options)
 }
 
-func rewrite(product, version, tag, srcdir string, markdown []byte) ([]byte, []*Image, error) {
+func rewrite(product, version, tag, srcdir string, markdown []byte, options ParseOptions, cache *imageCache) ([]byte, []*Image, error) {
+	var images []*Image
+	var rewriteErr error
+	referenced := make(map[*Image]bool)
+	reference := func(image *Image) {
+		cache.reference(image)
+		if !referenced[image] {
+			referenced[image] = true
+			images = append(images, image)
+			images = append(images, image.Variants...)
+		}
+	}
+
 	rewriteAnchors := func(bytes []byte) []byte {
 		// This match must succeed or we wouldn't have been invoked
 		href := string(AnchorRegexp.FindSubmatch(bytes)[1])
@@ -38,30 +55,179 @@ func rewrite(product, version, tag, srcdir string, markdown []byte) ([]byte, []*
 			return []byte(fmt.Sprintf(`<a href="/docs/%s/%s/%s/`, product, tag, trimmed))
 		}
 
+		// Upload linked files, which would otherwise 404
+		if filename := localAsset(srcdir, stdhtml.UnescapeString(href)); filename != "" && options.Assets.allowed(filename) {
+			if !insideSite(options.root, filename) {
+				rewriteErr = fmt.Errorf("link to %s is outside the site", stdhtml.UnescapeString(href))
+				return bytes
+			}
+			asset, err := cache.read(filename, func(filename string) (*Image, error) {
+				return ReadAsset(filename, options.Assets)
+			})
+			if err != nil {
+				rewriteErr = err
+				return bytes
+			}
+			reference(asset)
+			return []byte(`<a href="` + asset.placeholder())
+		}
+
 		return bytes
 	}
-
-	var images []*Image
-	var rewriteErr error
 	rewriteImages := func(bytes []byte) []byte {
 		// This match must succeed or we wouldn't have been invoked
 		src := string(ImgRegexp.FindSubmatch(bytes)[1])
 
-		image, err := ReadImage(stdpath.Join(srcdir, src))
+		image, err := cache.read(stdpath.Join(srcdir, src), func(filename string) (*Image, error) {
+			return ReadImage(filename, options.Images)
+		})
 		if err != nil {
 			rewriteErr = err
 			return bytes
 		}
 
-		images = append(images, image)
+		// The attachment takes the alt text and title of the first reference
+		var alt, title string
+		for _, attribute := range imgAttributeRegexp.FindAllSubmatch(bytes, -1) {
+			value := stdhtml.UnescapeString(string(attribute[2]))
+			switch string(attribute[1]) {
+			case "alt":
+				alt = value
+			case "title":
+				title = value
+			}
+		}
+		if image.Alt == "" && image.Title == "" {
+			image.Alt, image.Title = alt, title
+		}
+
+		if image.MimeType == svgMimeType && len(image.Content) <= options.Images.InlineSVGMaxSize {
+			return inlineSVG(image, alt)
+		}
+
+		reference(image)
+
+		replacement := fmt.Sprintf(`<img src="%s"`, image.placeholder())
+		if options.Images.Dimensions && image.Width > 0 && !imgSizeRegexp.Match(bytes) {
+			replacement += fmt.Sprintf(` width="%d" height="%d"`, image.Width, image.Height)
+		}
+		if len(image.Variants) > 0 && !imgSrcsetRegexp.Match(bytes) {
+			var srcset []string
+			for _, variant := range image.Variants {
+				srcset = append(srcset, fmt.Sprintf("%s %dw", variant.placeholder(), variant.Width))
+			}
+			srcset = append(srcset, fmt.Sprintf("%s %dw", image.placeholder(), image.Width))
 
-		return []byte(fmt.Sprintf(`<img src="/wp-content/uploads/%s%s"`, image.Hash, image.Extension))
+			sizes := options.Images.Sizes
+			if sizes == "" {
+				sizes = fmt.Sprintf("(max-width: %dpx) 100vw, %dpx", image.Width, image.Width)
+			}
+			replacement += fmt.Sprintf(` srcset="%s" sizes="%s" loading="lazy"`,
+				strings.Join(srcset, ", "), stdhtml.EscapeString(sizes))
+		}
+		return ImgRegexp.ReplaceAllLiteral(bytes, []byte(replacement))
 	}
 
 	html := convertToHTML(markdown)
 	html = AnchorRegexp.ReplaceAllFunc(html, rewriteAnchors)
-	html = ImgRegexp.ReplaceAllFunc(html, rewriteImages)
+	html = ImgTagRegexp.ReplaceAllFunc(html, rewriteImages)
 	html = BackslashRegexp.ReplaceAllLiteral(html, []byte(`&#092;`))
 
 	return html, images, rewriteErr
 }
+
+// inlineSVG returns the markup with which to replace an <img> tag referencing
+// a sanitised SVG, labelled with its alt text for accessibility
+func inlineSVG(image *Image, alt string) []byte {
+	svg := string(image.Content)
+	if alt != "" && strings.HasPrefix(svg, "<svg") {
+		svg = fmt.Sprintf(`<svg role="img" aria-label="%s"`, stdhtml.EscapeString(alt)) +
+			strings.TrimPrefix(svg, "<svg")
+	}
+	return []byte(svg)
+}
+
+// ResolveImages points the images and files referenced by a document (in src,
+// srcset and href attributes) at their uploaded URLs, given by urls keyed by image Name.
+// References to images without a known URL are left as they are.
+func (d *Document) ResolveImages(urls map[string]string) {
+	for _, image := range d.Images {
+		url, ok := urls[image.Name()]
+		if !ok {
+			continue
+		}
+		// The placeholder is also the tail of a typical URL, so only replace
+		// it at the start of an attribute value or srcset entry
+		for _, prefix := range []string{`"`, `, `} {
+			d.Content.Raw = strings.Replace(d.Content.Raw,
+				prefix+image.placeholder(), prefix+stdhtml.EscapeString(url), -1)
+		}
+	}
+}
+
+// SameContent reports whether a document's content matches that given. As in
+// a dry run, references to images not yet uploaded match any URL of the file
+// their upload would produce.
+func (d *Document) SameContent(content string) bool {
+	if d.Content.Raw == content {
+		return true
+	}
+	literals, names := d.splitPlaceholders()
+	if len(names) == 0 {
+		return false
+	}
+	return matchPlaceholders(literals, names, content)
+}
+
+// splitPlaceholders splits a document's content around the references to
+// images not yet uploaded, returning the text between them and the names of
+// the images referenced
+func (d *Document) splitPlaceholders() ([]string, []string) {
+	var literals, names []string
+	rest := d.Content.Raw
+	for {
+		at, prefix, token, name := -1, "", "", ""
+		for _, image := range d.Images {
+			// As in ResolveImages, only at the start of an attribute value or
+			// srcset entry
+			for _, p := range []string{`"`, `, `} {
+				t := p + image.placeholder()
+				i := strings.Index(rest, t)
+				if i >= 0 && (at < 0 || i < at || (i == at && len(t) > len(token))) {
+					at, prefix, token, name = i, p, t, image.Name()
+				}
+			}
+		}
+		if at < 0 {
+			return append(literals, rest), names
+		}
+		literals = append(literals, rest[:at+len(prefix)])
+		names = append(names, name)
+		rest = rest[at+len(token):]
+	}
+}
+
+// matchPlaceholders reports whether content consists of literals separated by
+// URLs ending in the corresponding names
+func matchPlaceholders(literals, names []string, content string) bool {
+	if !strings.HasPrefix(content, literals[0]) {
+		return false
+	}
+	content = content[len(literals[0]):]
+	if len(names) == 0 {
+		return content == ""
+	}
+
+	// The URL lies within a single attribute value or srcset entry
+	end := strings.IndexAny(content, "\"\t\n\f\r ")
+	if end < 0 {
+		end = len(content)
+	}
+	suffix := "/" + names[0]
+	for i := len(suffix); i <= end; i++ {
+		if strings.HasSuffix(content[:i], suffix) && matchPlaceholders(literals[1:], names[1:], content[i:]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package wordepress
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testPNG returns an encoded PNG of the given dimensions
+func testPNG(t *testing.T, width, height int) string {
+	decoded := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range decoded.Pix {
+		decoded.Pix[i] = byte(i)
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, decoded); err != nil {
+		t.Fatal(err)
+	}
+	return encoded.String()
+}
+
+func TestSameContent(t *testing.T) {
+	image := newImage("images/diagram.png", []byte("not really a PNG"), 0, 0)
+	document := &Document{
+		Content: Text{Raw: `<p>See <img src="` + image.placeholder() + `" alt="diagram" /></p>`},
+		Images:  []*Image{image}}
+
+	tests := []struct {
+		content string
+		same    bool
+	}{
+		{document.Content.Raw, true},
+		// Wherever WordPress would put the upload
+		{`<p>See <img src="https://docs.example.com/wp-content/uploads/2021/03/` + image.Name() + `" alt="diagram" /></p>`, true},
+		{`<p>See <img src="https://docs.example.com/wp-content/uploads/2021/03/other.png" alt="diagram" /></p>`, false},
+		{`<p>Look at <img src="https://docs.example.com/wp-content/uploads/` + image.Name() + `" alt="diagram" /></p>`, false},
+	}
+	for _, test := range tests {
+		if document.SameContent(test.content) != test.same {
+			t.Errorf("%s: same %v", test.content, !test.same)
+		}
+	}
+
+	// Several references, in srcset too, amid text that would be special in
+	// a regular expression
+	small := newImage("images/diagram-200w.png", []byte("smaller"), 0, 0)
+	srcset := &Document{
+		Content: Text{Raw: `<p>(a+b)*$ <img src="` + image.placeholder() + `" srcset="` + small.placeholder() + ` 200w, ` +
+			image.placeholder() + ` 800w" /></p>`},
+		Images: []*Image{image, small}}
+	uploads := "https://docs.example.com/wp-content/uploads/2021/03/"
+	for _, test := range []struct {
+		content string
+		same    bool
+	}{
+		{`<p>(a+b)*$ <img src="` + uploads + image.Name() + `" srcset="` + uploads + small.Name() + ` 200w, ` +
+			uploads + image.Name() + ` 800w" /></p>`, true},
+		{`<p>(a+b)*$ <img src="` + uploads + image.Name() + `" srcset="` + uploads + image.Name() + ` 200w, ` +
+			uploads + image.Name() + ` 800w" /></p>`, false},
+		// A URL can't span attributes
+		{`<p>(a+b)*$ <img src="x" alt="` + uploads + image.Name() + `" srcset="` + uploads + small.Name() + ` 200w, ` +
+			uploads + image.Name() + ` 800w" /></p>`, false},
+		{`<p>ab <img src="` + uploads + image.Name() + `" /></p>`, false},
+	} {
+		if srcset.SameContent(test.content) != test.same {
+			t.Errorf("%s: same %v", test.content, !test.same)
+		}
+	}
+
+	// Without images, only identical content matches
+	plain := &Document{Content: Text{Raw: "<p>(a+b)*$</p>"}}
+	if plain.SameContent("<p>(a+b)*$ </p>") {
+		t.Errorf("differing content without images is the same")
+	}
+
+	// Once uploaded, only its URL matches
+	document.ResolveImages(map[string]string{image.Name(): "https://docs.example.com/wp-content/uploads/" + image.Name()})
+	if document.SameContent(tests[1].content) {
+		t.Errorf("%s: same after resolving", tests[1].content)
+	}
+}
+
+func TestReadImageVariants(t *testing.T) {
+	dir := t.TempDir()
+	writeSite(t, dir, map[string]string{"diagram.png": testPNG(t, 800, 400)})
+	filename := filepath.Join(dir, "diagram.png")
+
+	// Widths as wide as the image are left out
+	image, err := ReadImage(filename, ImageOptions{SrcsetWidths: []int{200, 400, 800, 1600}})
+	if err != nil {
+		t.Fatalf("ReadImage: %v", err)
+	}
+	if image.Width != 800 || len(image.Variants) != 2 {
+		t.Fatalf("width %d with %d variants, want 800 with 2", image.Width, len(image.Variants))
+	}
+	for i, width := range []int{200, 400} {
+		variant := image.Variants[i]
+		if variant.Width != width || variant.Height != width/2 {
+			t.Errorf("variant %d is %dx%d, want %dx%d", i, variant.Width, variant.Height, width, width/2)
+		}
+		if want := fmt.Sprintf("diagram-%dw.png", width); filepath.Base(variant.Filename) != want {
+			t.Errorf("variant %d named %s, want %s", i, filepath.Base(variant.Filename), want)
+		}
+		config, err := png.DecodeConfig(bytes.NewReader(variant.Content))
+		if err != nil || config.Width != width {
+			t.Errorf("variant %d encoded %d wide: %v", i, config.Width, err)
+		}
+		if variant.Hash == image.Hash {
+			t.Errorf("variant %d shares the image's hash", i)
+		}
+	}
+}
+
+func TestRewriteSrcset(t *testing.T) {
+	dir := t.TempDir()
+	writeSite(t, dir, map[string]string{"diagram.png": testPNG(t, 800, 400)})
+
+	tests := []struct {
+		name     string
+		markdown string
+		sizes    string
+		want     string
+	}{
+		{"default sizes", "![Diagram](diagram.png)", "",
+			` srcset="%s 200w, %s 400w, %s 800w" sizes="(max-width: 800px) 100vw, 800px" loading="lazy"`},
+		{"sizes", "![Diagram](diagram.png)", "(min-width: 40em) 50vw, 100vw",
+			` srcset="%s 200w, %s 400w, %s 800w" sizes="(min-width: 40em) 50vw, 100vw" loading="lazy"`},
+		{"own srcset", `<img src="diagram.png" srcset="diagram.png 1x">`, "", ""},
+	}
+	for _, test := range tests {
+		options := ParseOptions{Images: ImageOptions{SrcsetWidths: []int{200, 400}, Sizes: test.sizes}, root: dir}
+		html, images, err := rewrite("net", "1.0", "latest", dir, []byte(test.markdown), options, newImageCache())
+		if err != nil {
+			t.Fatalf("%s: rewrite: %v", test.name, err)
+		}
+		if len(images) != 3 {
+			t.Fatalf("%s: %d images, want the image and 2 variants", test.name, len(images))
+		}
+		image := images[0]
+		if !strings.Contains(string(html), `<img src="`+image.placeholder()+`"`) {
+			t.Errorf("%s: %s doesn't reference the upload", test.name, html)
+		}
+		if test.want == "" {
+			if strings.Contains(string(html), "loading=") {
+				t.Errorf("%s: %s has a second srcset", test.name, html)
+			}
+			continue
+		}
+		want := fmt.Sprintf(test.want, image.Variants[0].placeholder(), image.Variants[1].placeholder(), image.placeholder())
+		if !strings.Contains(string(html), want) {
+			t.Errorf("%s: %s lacks %s", test.name, html, want)
+		}
+	}
+}
+
+func TestResolveImagesSrcset(t *testing.T) {
+	dir := t.TempDir()
+	writeSite(t, dir, map[string]string{"diagram.png": testPNG(t, 800, 400)})
+
+	options := ParseOptions{Images: ImageOptions{SrcsetWidths: []int{200, 400}}, root: dir}
+	html, images, err := rewrite("net", "1.0", "latest", dir, []byte("![Diagram](diagram.png)"), options, newImageCache())
+	if err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	document := &Document{Content: Text{Raw: string(html)}, Images: images}
+
+	urls := make(map[string]string)
+	for _, image := range images {
+		urls[image.Name()] = "https://docs.example.com/wp-content/uploads/2021/03/" + image.Name()
+	}
+	document.ResolveImages(urls)
+
+	image := images[0]
+	want := fmt.Sprintf(`<img src="%s" srcset="%s 200w, %s 400w, %s 800w"`,
+		urls[image.Name()], urls[image.Variants[0].Name()], urls[image.Variants[1].Name()], urls[image.Name()])
+	if !strings.Contains(document.Content.Raw, want) {
+		t.Errorf("%s lacks %s", document.Content.Raw, want)
+	}
+	if strings.Contains(document.Content.Raw, `"/wp-content/`) || strings.Contains(document.Content.Raw, `, /wp-content/`) {
+		t.Errorf("%s has unresolved references", document.Content.Raw)
+	}
+}
@@ -0,0 +1,245 @@
+package wordepress
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const svgMimeType = "image/svg+xml"
+
+// Elements removed from SVGs along with their content, as they can run script
+// or embed other documents. Keyed in lower case, as an SVG inlined into HTML
+// is parsed without regard to case.
+var unsafeSVGElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+}
+
+var cssURLRegexp = regexp.MustCompile(`url\(\s*['"]?\s*([^'")\s]*)\s*['"]?\s*\)`)
+var cssImportRegexp = regexp.MustCompile(`@import[^;]*;?`)
+var svgLengthRegexp = regexp.MustCompile(`^\s*([0-9]*\.?[0-9]+)\s*(px)?\s*$`)
+
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+var attributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+// isSVG reports whether content is an SVG document, which
+// http.DetectContentType can't recognise
+func isSVG(content []byte) bool {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		token, err := decoder.RawToken()
+		if err != nil {
+			return false
+		}
+		if element, ok := token.(xml.StartElement); ok {
+			return strings.EqualFold(element.Name.Local, "svg")
+		}
+	}
+}
+
+// stripControls removes the ASCII whitespace and control characters that
+// browsers ignore within URLs, so that "java\tscript:" can't pass for
+// something else
+func stripControls(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7F {
+			return -1
+		}
+		return r
+	}, value)
+}
+
+// safeReference reports whether a URL may be referred to by a sanitised SVG:
+// only fragments within the document itself and embedded raster images
+func safeReference(url string) bool {
+	url = strings.ToLower(stripControls(url))
+	return url == "" || strings.HasPrefix(url, "#") ||
+		strings.HasPrefix(url, "data:image/png") ||
+		strings.HasPrefix(url, "data:image/jpeg") ||
+		strings.HasPrefix(url, "data:image/gif") ||
+		strings.HasPrefix(url, "data:image/webp")
+}
+
+// safeReferences reports whether every URL of an animation's
+// semicolon-separated values is a safe reference
+func safeReferences(values string) bool {
+	for _, value := range strings.Split(values, ";") {
+		if !safeReference(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// sanitiseCSS neutralises stylesheet imports and references to external
+// resources
+func sanitiseCSS(css string) string {
+	css = cssImportRegexp.ReplaceAllString(css, "")
+	return cssURLRegexp.ReplaceAllStringFunc(css, func(match string) string {
+		if safeReference(cssURLRegexp.FindStringSubmatch(match)[1]) {
+			return match
+		}
+		return "none"
+	})
+}
+
+func svgName(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+// sanitiseSVG rewrites an SVG without scripts, event handlers, embedded
+// documents or references to external resources, along with comments,
+// processing instructions and DOCTYPEs (which may declare entities)
+func sanitiseSVG(content []byte) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	var (
+		out      bytes.Buffer
+		depth    int
+		dropping int
+		inStyle  bool
+	)
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid SVG: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			element := strings.ToLower(t.Name.Local)
+			if dropping > 0 || unsafeSVGElements[element] {
+				dropping++
+				continue
+			}
+			inStyle = element == "style"
+
+			// Animations may set a link, in which case the values they set
+			// are references too
+			animatesLink := false
+			for _, attribute := range t.Attr {
+				if strings.ToLower(attribute.Name.Local) == "attributename" && strings.HasSuffix(strings.ToLower(strings.TrimSpace(attribute.Value)), "href") {
+					animatesLink = true
+				}
+			}
+
+			out.WriteString("<" + svgName(t.Name))
+			for _, attribute := range t.Attr {
+				name := strings.ToLower(attribute.Name.Local)
+				value := attribute.Value
+				switch {
+				case strings.HasPrefix(name, "on"):
+					continue
+				case strings.ToLower(attribute.Name.Space) == "xml" && name == "base":
+					continue
+				case name == "href" || name == "src":
+					if !safeReference(value) {
+						continue
+					}
+				case animatesLink && (name == "to" || name == "from" || name == "by" || name == "values"):
+					if !safeReferences(value) {
+						continue
+					}
+				case name == "style":
+					value = sanitiseCSS(value)
+				case strings.Contains(strings.ToLower(stripControls(value)), "javascript:"):
+					continue
+				default:
+					value = sanitiseCSS(value)
+				}
+				out.WriteString(" " + svgName(attribute.Name) + `="` + attributeEscaper.Replace(value) + `"`)
+			}
+			out.WriteString(">")
+
+		case xml.EndElement:
+			depth--
+			if dropping > 0 {
+				dropping--
+				continue
+			}
+			inStyle = false
+			out.WriteString("</" + svgName(t.Name) + ">")
+
+		case xml.CharData:
+			if dropping > 0 || depth == 0 {
+				continue
+			}
+			text := string(t)
+			if inStyle {
+				text = sanitiseCSS(text)
+			}
+			out.WriteString(textEscaper.Replace(text))
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// svgDimensions returns the intrinsic size of an SVG from the width and
+// height of its root element, completed from its viewBox if need be
+func svgDimensions(content []byte) (int, int) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		token, err := decoder.RawToken()
+		if err != nil {
+			return 0, 0
+		}
+		root, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		var width, height, boxWidth, boxHeight float64
+		for _, attribute := range root.Attr {
+			switch attribute.Name.Local {
+			case "width":
+				width = svgLength(attribute.Value)
+			case "height":
+				height = svgLength(attribute.Value)
+			case "viewBox":
+				box := strings.Fields(strings.Replace(attribute.Value, ",", " ", -1))
+				if len(box) == 4 {
+					boxWidth, _ = strconv.ParseFloat(box[2], 64)
+					boxHeight, _ = strconv.ParseFloat(box[3], 64)
+				}
+			}
+		}
+
+		if boxWidth > 0 && boxHeight > 0 {
+			switch {
+			case width == 0 && height == 0:
+				width, height = boxWidth, boxHeight
+			case height == 0:
+				height = width * boxHeight / boxWidth
+			case width == 0:
+				width = height * boxWidth / boxHeight
+			}
+		}
+		return int(width + 0.5), int(height + 0.5)
+	}
+}
+
+// svgLength parses a length in pixels, returning zero for relative units
+func svgLength(value string) float64 {
+	match := svgLengthRegexp.FindStringSubmatch(value)
+	if match == nil {
+		return 0
+	}
+	length, _ := strconv.ParseFloat(match[1], 64)
+	return length
+}
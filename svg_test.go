@@ -0,0 +1,91 @@
+package wordepress
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitiseSVG(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"script",
+			`<svg><script>alert(1)</script><g><foreignObject><p>hi</p></foreignObject></g></svg>`,
+			`<svg><g></g></svg>`},
+		{"script in any case",
+			`<svg><Script>alert(1)</Script><SCRIPT>alert(2)</SCRIPT><foreignobject><p>hi</p></foreignobject><FOREIGNOBJECT/><svg:script xmlns:svg="http://www.w3.org/2000/svg">alert(3)</svg:script></svg>`,
+			`<svg></svg>`},
+		{"css in any case",
+			`<svg><STYLE>@import "https://example.com/a.css";</STYLE><set AttributeName="HREF" to="javascript:alert(1)"/></svg>`,
+			`<svg><STYLE></STYLE><set AttributeName="HREF"></set></svg>`},
+		{"event handlers",
+			`<svg onload="alert(1)"><rect ONCLICK="alert(1)" width="10"/></svg>`,
+			`<svg><rect width="10"></rect></svg>`},
+		{"comments and doctype",
+			`<?xml version="1.0"?><!DOCTYPE svg [<!ENTITY x "y">]><!-- note --><svg></svg>`,
+			`<svg></svg>`},
+		{"fragment and raster links kept",
+			`<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use xlink:href="#icon"/><image href="data:image/png;base64,AAAA"/></svg>`,
+			`<svg xmlns:xlink="http://www.w3.org/1999/xlink"><use xlink:href="#icon"></use><image href="data:image/png;base64,AAAA"></image></svg>`},
+		{"external links dropped",
+			`<svg><a href="https://example.com/"><text>x</text></a><image href="data:image/svg+xml;base64,AAAA"/></svg>`,
+			`<svg><a><text>x</text></a><image></image></svg>`},
+		{"script links dropped",
+			`<svg><a href="javascript:alert(1)"/><a href="java&#9;script:alert(1)"/><a href=" &#10;JavaScript:alert(1)"/></svg>`,
+			`<svg><a></a><a></a><a></a></svg>`},
+		{"script values dropped",
+			`<svg><rect filter="java&#x0A;script:alert(1)" fill="red"/></svg>`,
+			`<svg><rect fill="red"></rect></svg>`},
+		{"animated links",
+			`<svg><a><set attributeName="href" to="javascript:alert(1)"/><animate attributeName="xlink:href" values="#a;java&#9;script:alert(1)"/><set attributeName="href" to="#b"/></a></svg>`,
+			`<svg><a><set attributeName="href"></set><animate attributeName="xlink:href"></animate><set attributeName="href" to="#b"></set></a></svg>`},
+		{"xml:base",
+			`<svg xml:base="https://example.com/"></svg>`,
+			`<svg></svg>`},
+		{"css",
+			`<svg><style>@import "https://example.com/a.css"; rect { fill: url(#g); background: url('https://example.com/x.png') }</style><rect style="fill: url( https://example.com/y )" mask="url(#m)"/></svg>`,
+			`<svg><style> rect { fill: url(#g); background: none }</style><rect style="fill: none" mask="url(#m)"></rect></svg>`},
+		{"escaping",
+			`<svg><text title="a &quot;b&quot; &amp; c">1 &lt; 2</text></svg>`,
+			`<svg><text title="a &quot;b&quot; &amp; c">1 &lt; 2</text></svg>`},
+	}
+	for _, test := range tests {
+		out, err := sanitiseSVG([]byte(test.in))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(out) != test.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", test.name, out, test.want)
+		}
+	}
+
+	if _, err := sanitiseSVG([]byte(`<svg><g width="1`)); err == nil || !strings.HasPrefix(err.Error(), "invalid SVG") {
+		t.Errorf("malformed SVG: got %v", err)
+	}
+}
+
+func TestSVGDimensions(t *testing.T) {
+	tests := []struct {
+		svg           string
+		width, height int
+	}{
+		{`<svg width="100" height="50px"></svg>`, 100, 50},
+		{`<svg viewBox="0 0 200 100"></svg>`, 200, 100},
+		{`<svg width="50" viewBox="0,0,200,100"></svg>`, 50, 25},
+		{`<svg height="50" viewBox="0 0 200 100"></svg>`, 100, 50},
+		{`<svg width="100%" height="2em"></svg>`, 0, 0},
+		{`<?xml version="1.0"?><svg width="10.6" height="10"></svg>`, 11, 10},
+	}
+	for _, test := range tests {
+		if width, height := svgDimensions([]byte(test.svg)); width != test.width || height != test.height {
+			t.Errorf("%s: %dx%d, want %dx%d", test.svg, width, height, test.width, test.height)
+		}
+	}
+
+	if !isSVG([]byte(`<?xml version="1.0"?><!-- x --><svg></svg>`)) || !isSVG([]byte(`<SVG></SVG>`)) || isSVG([]byte(`<html></html>`)) || isSVG([]byte("not xml")) {
+		t.Error("isSVG misidentified a document")
+	}
+}
@@ -0,0 +1,149 @@
+package wordepress
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// termSlug approximates the slug WordPress derives from a term name
+func termSlug(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, "-")
+}
+
+// TermIDs returns the IDs of the terms with the given names in a taxonomy,
+// identified by its REST base (e.g. "categories" or "tags"). Terms that don't
+// exist are created if create is set, and otherwise omitted.
+func (c *Client) TermIDs(ctx context.Context, taxonomy string, names []string, create bool) (map[string]int, error) {
+	endpoint := c.BaseURL + "/wp-json/wp/v2/" + taxonomy
+
+	var slugs []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		slug := termSlug(name)
+		if !seen[slug] {
+			seen[slug] = true
+			slugs = append(slugs, url.QueryEscape(slug))
+		}
+	}
+	if len(slugs) == 0 {
+		return map[string]int{}, nil
+	}
+
+	found, err := c.findTerms(ctx, endpoint, slugs)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]int)
+	for _, name := range names {
+		slug := termSlug(name)
+		id, ok := found[slug]
+		if !ok && create {
+			id, err = c.createTerm(ctx, endpoint, name, slug)
+			if err != nil {
+				return nil, fmt.Errorf("creating %s %q: %w", taxonomy, name, err)
+			}
+			found[slug], ok = id, true
+		}
+		if ok {
+			ids[name] = id
+		}
+	}
+	return ids, nil
+}
+
+func (c *Client) findTerms(ctx context.Context, endpoint string, slugs []string) (map[string]int, error) {
+	pages, err := c.getPages(ctx, endpoint, "per_page=100&_fields=id,slug&slug="+strings.Join(slugs, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]int)
+	for _, page := range pages {
+		for _, object := range page {
+			var term struct {
+				ID   int    `json:"id"`
+				Slug string `json:"slug"`
+			}
+			if err := json.Unmarshal(object, &term); err != nil {
+				return nil, err
+			}
+			// Slugs of terms with non-ASCII names are percent encoded
+			slug, err := url.PathUnescape(term.Slug)
+			if err != nil {
+				slug = term.Slug
+			}
+			found[slug] = term.ID
+		}
+	}
+	return found, nil
+}
+
+func (c *Client) createTerm(ctx context.Context, endpoint, name, slug string) (int, error) {
+	requestBytes, err := json.Marshal(map[string]string{"name": name, "slug": slug})
+	if err != nil {
+		return 0, err
+	}
+
+	request, err := c.newRequest(ctx, "POST", endpoint, bytes.NewReader(requestBytes))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	var applied int
+	probe := func(ctx context.Context) (bool, error) {
+		found, err := c.findTerms(ctx, endpoint, []string{url.QueryEscape(slug)})
+		applied = found[slug]
+		return applied != 0, err
+	}
+
+	response, responseBytes, err := c.do(request, probe)
+	if err == errApplied {
+		return applied, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if response.StatusCode != http.StatusCreated {
+		apiError := newAPIError(response, responseBytes)
+		// A term of the same name whose slug we failed to predict
+		if apiError.Code == "term_exists" && apiError.Data.TermID != 0 {
+			return apiError.Data.TermID, nil
+		}
+		return 0, apiError
+	}
+
+	var term struct {
+		ID int `json:"id"`
+	}
+	err = json.Unmarshal(responseBytes, &term)
+	return term.ID, err
+}
+
+// UserID returns the ID of the user with the given slug (by default their
+// username)
+func (c *Client) UserID(ctx context.Context, slug string) (int, error) {
+	var users []struct {
+		ID int `json:"id"`
+	}
+	endpoint := fmt.Sprintf("%s/wp-json/wp/v2/users?slug=%s&_fields=id", c.BaseURL, url.QueryEscape(slug))
+	if _, err := c.getJSON(ctx, endpoint, &users); err != nil {
+		return 0, err
+	}
+	if len(users) == 0 {
+		return 0, errors.New("no such user: " + slug)
+	}
+	return users[0].ID, nil
+}
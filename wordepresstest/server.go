@@ -0,0 +1,853 @@
+// Package wordepresstest provides an in-memory stand-in for the subset of the
+// WordPress REST API used by wordepress, for exercising clients offline.
+package wordepresstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	restPrefix    = "/wp-json/wp/v2/"
+	batchPath     = "/wp-json/batch/v1"
+	postTypesPath = "/wp-json/wordepress/v1/post-types"
+	uploadsPrefix = "/wp-content/uploads/"
+
+	// MaxBatchSize is the default limit on requests per batch in WordPress
+	MaxBatchSize = 25
+)
+
+var itemRegexp = regexp.MustCompile(`^([a-z0-9_-]+)(?:/([0-9]+)(/revisions)?)?$`)
+
+// APIUser is the ID of the user REST requests are made as, for the purposes of
+// recording revisions
+const APIUser = 1
+
+// Record is the stored JSON representation of a post or attachment
+type Record map[string]interface{}
+
+type Server struct {
+	*httptest.Server
+
+	// User and Password, if set, are required as basic authentication
+	// credentials on every REST request
+	User     string
+	Password string
+
+	// Token, if set, is accepted as an Authorization: Bearer credential in
+	// place of basic authentication
+	Token string
+
+	// EmptyPastLastPage makes requests for a page beyond the last return an
+	// empty list, as WordPress did prior to 4.7, instead of a 400 error
+	EmptyPastLastPage bool
+
+	// DisableBatch removes the batch API, as on WordPress prior to 5.6
+	DisableBatch bool
+
+	// UploadsByMonth stores uploads in year/month folders, as WordPress does
+	// when "Organize my uploads into month- and year-based folders" is set
+	UploadsByMonth bool
+
+	// PostTypes are the REST bases listed by the Wordepress plugin's post
+	// types route, by default just "documentation". Nil removes the route, as
+	// with versions of the plugin before 1.8.0.
+	PostTypes []string
+
+	// Meta are the post meta keys described in the schema of each post type,
+	// as registered with show_in_rest
+	Meta []string
+
+	mu        sync.Mutex
+	nextID    int
+	posts     map[string]map[int]Record
+	revisions map[int][]Record
+	uploads   map[string][]byte
+}
+
+// NewServer starts and returns a new fake WordPress. The caller should call
+// Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		nextID:    1,
+		posts:     make(map[string]map[int]Record),
+		revisions: make(map[int][]Record),
+		uploads:   make(map[string][]byte),
+		PostTypes: []string{"documentation"}}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Posts returns a copy of every record of the given REST base (e.g.
+// "documentation" or "media"), ordered by ID
+func (s *Server) Posts(restBase string) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []Record
+	for _, record := range s.sorted(restBase) {
+		records = append(records, copyRecord(record))
+	}
+	return records
+}
+
+// AddPost stores a record under the given REST base as though it had been
+// created through the API, returning its ID
+func (s *Server) AddPost(restBase string, fields Record) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.newRecord(restBase)
+	s.merge(record, fields)
+	record["slug"] = s.uniqueSlug(restBase, stringField(record, "slug"), intField(record, "id"))
+	return intField(record, "id")
+}
+
+// EditPost applies fields to an existing record as though a user had edited it
+// in the WordPress admin, recording a revision authored by that user
+func (s *Server) EditPost(restBase string, id, author int, fields Record) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.posts[restBase][id]
+	if !ok {
+		return false
+	}
+	s.merge(record, fields)
+	touch(record)
+	s.revise(record, author)
+	return true
+}
+
+// AddUser creates a user with the given display name, returning its ID
+func (s *Server) AddUser(name string) int {
+	return s.AddPost("users", Record{"name": name, "slug": strings.ToLower(name)})
+}
+
+// Upload returns the content of a file in the uploads directory
+func (s *Server) Upload(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, ok := s.uploads[name]
+	return content, ok
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, uploadsPrefix) {
+		s.serveUpload(w, r)
+		return
+	}
+
+	if r.URL.Path == batchPath && !s.DisableBatch {
+		s.serveBatch(w, r)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, restPrefix) && (r.URL.Path != postTypesPath || s.PostTypes == nil) {
+		writeError(w, http.StatusNotFound, "rest_no_route", "No route was found matching the URL and request method", nil)
+		return
+	}
+
+	if s.Token != "" && r.Header.Get("Authorization") == "Bearer "+s.Token {
+		// Authenticated by token
+	} else if s.User != "" || s.Password != "" || s.Token != "" {
+		user, password, ok := r.BasicAuth()
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "rest_not_logged_in", "You are not currently logged in.", nil)
+			return
+		}
+		if user != s.User || password != s.Password {
+			writeError(w, http.StatusUnauthorized, "incorrect_password", "The provided password is an invalid application password.", nil)
+			return
+		}
+	}
+
+	if r.URL.Path == postTypesPath {
+		types := []Record{}
+		for _, restBase := range s.PostTypes {
+			types = append(types, Record{"name": restBase, "rest_base": restBase})
+		}
+		writeJSON(w, http.StatusOK, types)
+		return
+	}
+
+	matches := itemRegexp.FindStringSubmatch(strings.TrimPrefix(r.URL.Path, restPrefix))
+	if matches == nil {
+		writeError(w, http.StatusNotFound, "rest_no_route", "No route was found matching the URL and request method", nil)
+		return
+	}
+	restBase := matches[1]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if matches[2] == "" {
+		switch {
+		case r.Method == "GET":
+			s.list(w, r, restBase)
+		case r.Method == "OPTIONS":
+			s.schema(w, restBase)
+		case r.Method == "POST" && restBase == "media":
+			s.upload(w, r)
+		case r.Method == "POST":
+			s.create(w, r, restBase)
+		default:
+			writeError(w, http.StatusNotFound, "rest_no_route", "No route was found matching the URL and request method", nil)
+		}
+		return
+	}
+
+	id, _ := strconv.Atoi(matches[2])
+	record, ok := s.posts[restBase][id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "rest_post_invalid_id", "Invalid post ID.", nil)
+		return
+	}
+
+	if matches[3] != "" {
+		if r.Method != "GET" || restBase == "media" || restBase == "users" {
+			writeError(w, http.StatusNotFound, "rest_no_route", "No route was found matching the URL and request method", nil)
+			return
+		}
+		s.listRevisions(w, r, id)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		query := r.URL.Query()
+		writeJSON(w, http.StatusOK, project(s.view(record, query.Get("context")), query.Get("_fields")))
+	case "POST", "PUT", "PATCH":
+		s.update(w, r, restBase, record)
+	case "DELETE":
+		s.delete(w, r, restBase, record)
+	default:
+		writeError(w, http.StatusNotFound, "rest_no_route", "No route was found matching the URL and request method", nil)
+	}
+}
+
+// serveBatch implements the batch API by dispatching each sub-request back
+// through the server, as WordPress does internally
+func (s *Server) serveBatch(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "OPTIONS":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"namespace": "batch/v1",
+			"methods":   []string{"POST"},
+			"endpoints": []interface{}{map[string]interface{}{
+				"methods": []string{"POST"},
+				"args": map[string]interface{}{
+					"requests": map[string]interface{}{
+						"type":     "array",
+						"maxItems": MaxBatchSize}}}}})
+		return
+	case "POST":
+	default:
+		writeError(w, http.StatusNotFound, "rest_no_route", "No route was found matching the URL and request method", nil)
+		return
+	}
+
+	var batch struct {
+		Requests []struct {
+			Method string          `json:"method"`
+			Path   string          `json:"path"`
+			Body   json.RawMessage `json:"body"`
+		} `json:"requests"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		writeError(w, http.StatusBadRequest, "rest_invalid_json", "Invalid JSON body passed.", nil)
+		return
+	}
+	if len(batch.Requests) > MaxBatchSize {
+		writeError(w, http.StatusBadRequest, "rest_invalid_param", "Invalid parameter(s): requests",
+			map[string]interface{}{"requests": fmt.Sprintf("requests must contain at most %d items.", MaxBatchSize)})
+		return
+	}
+
+	var responses []interface{}
+	for _, request := range batch.Requests {
+		method := request.Method
+		if method == "" {
+			method = "POST"
+		}
+		sub := httptest.NewRequest(method, "/wp-json"+request.Path, strings.NewReader(string(request.Body)))
+		sub.Header = r.Header.Clone()
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, sub)
+
+		var body interface{}
+		json.Unmarshal(recorder.Body.Bytes(), &body)
+		responses = append(responses, map[string]interface{}{
+			"body":    body,
+			"status":  recorder.Code,
+			"headers": map[string]interface{}{}})
+	}
+
+	writeJSON(w, http.StatusMultiStatus, map[string]interface{}{"responses": responses})
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, restBase string) {
+	query := r.URL.Query()
+
+	perPage := 10
+	if value := query.Get("per_page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 100 {
+			writeError(w, http.StatusBadRequest, "rest_invalid_param", "Invalid parameter(s): per_page",
+				map[string]interface{}{"per_page": "per_page must be between 1 (inclusive) and 100 (inclusive)"})
+			return
+		}
+		perPage = n
+	}
+
+	page := 1
+	if value := query.Get("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "rest_invalid_param", "Invalid parameter(s): page",
+				map[string]interface{}{"page": "page must be greater than or equal to 1"})
+			return
+		}
+		page = n
+	}
+
+	var matched []Record
+	for _, record := range s.sorted(restBase) {
+		if matchesQuery(record, query) {
+			matched = append(matched, record)
+		}
+	}
+
+	total := len(matched)
+	totalPages := (total + perPage - 1) / perPage
+	if page > totalPages && total > 0 && !s.EmptyPastLastPage {
+		writeError(w, http.StatusBadRequest, "rest_post_invalid_page_number",
+			"The page number requested is larger than the number of pages available.", nil)
+		return
+	}
+
+	views := []Record{}
+	for i := (page - 1) * perPage; i < total && i < page*perPage; i++ {
+		views = append(views, project(s.view(matched[i], query.Get("context")), query.Get("_fields")))
+	}
+
+	w.Header().Set("X-WP-Total", strconv.Itoa(total))
+	w.Header().Set("X-WP-TotalPages", strconv.Itoa(totalPages))
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request, restBase string) {
+	var fields Record
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		writeError(w, http.StatusBadRequest, "rest_invalid_json", "Invalid JSON body passed.", nil)
+		return
+	}
+
+	s.dropPluginFields(restBase, fields)
+	record := s.newRecord(restBase)
+	s.merge(record, fields)
+	record["slug"] = s.uniqueSlug(restBase, stringField(record, "slug"), intField(record, "id"))
+	recordChecksum(record, fields)
+	s.revise(record, APIUser)
+	writeJSON(w, http.StatusCreated, s.view(record, "edit"))
+}
+
+func (s *Server) update(w http.ResponseWriter, r *http.Request, restBase string, record Record) {
+	var fields Record
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		writeError(w, http.StatusBadRequest, "rest_invalid_json", "Invalid JSON body passed.", nil)
+		return
+	}
+
+	s.dropPluginFields(restBase, fields)
+	s.merge(record, fields)
+	if _, ok := fields["slug"]; ok {
+		record["slug"] = s.uniqueSlug(restBase, stringField(record, "slug"), intField(record, "id"))
+	}
+	touch(record)
+	recordChecksum(record, fields)
+	s.revise(record, APIUser)
+	writeJSON(w, http.StatusOK, s.view(record, "edit"))
+}
+
+// ChecksumField is the field in which the Wordepress plugin records when a
+// post was saved
+const ChecksumField = "wordepress-checksum"
+
+// LinkField is the field in which the Wordepress plugin stores the target of
+// a post standing in for a link
+const LinkField = "wordepress-link"
+
+// pluginFields reports whether the Wordepress plugin registers its fields for
+// a REST base
+func (s *Server) pluginFields(restBase string) bool {
+	for _, postType := range s.PostTypes {
+		if postType == restBase {
+			return true
+		}
+	}
+	return false
+}
+
+// dropPluginFields ignores the Wordepress plugin's fields when sent for a post
+// type it doesn't register them for, as WordPress ignores unknown fields
+func (s *Server) dropPluginFields(restBase string, fields Record) {
+	if !s.pluginFields(restBase) {
+		delete(fields, ChecksumField)
+		delete(fields, LinkField)
+	}
+}
+
+// schema describes a post type's fields, as WordPress does in response to an
+// OPTIONS request for its collection
+func (s *Server) schema(w http.ResponseWriter, restBase string) {
+	meta := map[string]interface{}{}
+	for _, key := range s.Meta {
+		meta[key] = map[string]interface{}{"type": "string"}
+	}
+	properties := map[string]interface{}{
+		"meta": map[string]interface{}{"type": "object", "properties": meta}}
+	for _, name := range []string{"id", "date_gmt", "modified_gmt", "slug", "status", "title", "content",
+		"excerpt", "parent", "menu_order", "author", "featured_media", "template", "categories", "tags"} {
+		properties[name] = map[string]interface{}{}
+	}
+	if s.pluginFields(restBase) {
+		properties[ChecksumField] = map[string]interface{}{"type": "string"}
+		properties[LinkField] = map[string]interface{}{"type": "string"}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"namespace": "wp/v2",
+		"methods":   []string{"GET", "POST"},
+		"schema": map[string]interface{}{
+			"title":      restBase,
+			"type":       "object",
+			"properties": properties}})
+}
+
+// recordChecksum records when a post was saved if the checksum field was
+// sent, as the Wordepress plugin does
+func recordChecksum(record, fields Record) {
+	if value, ok := fields[ChecksumField].(string); ok && value != "" {
+		record[ChecksumField] = record["modified_gmt"]
+	}
+}
+
+// touch updates the modification time of a record. Times are to the second,
+// as in WordPress, but always move on so that tests can tell apart saves in
+// quick succession.
+func touch(record Record) {
+	modified := now()
+	if previous := stringField(record, "modified_gmt"); modified <= previous {
+		t, _ := time.Parse("2006-01-02T15:04:05", previous)
+		modified = t.Add(time.Second).Format("2006-01-02T15:04:05")
+	}
+	record["modified_gmt"] = modified
+}
+
+// revise records a revision of a record's current content by author
+func (s *Server) revise(record Record, author int) {
+	id := intField(record, "id")
+	revision := Record{
+		"id":           s.nextID,
+		"author":       author,
+		"parent":       id,
+		"modified_gmt": record["modified_gmt"],
+		"title":        record["title"],
+		"content":      record["content"]}
+	s.nextID++
+	s.revisions[id] = append(s.revisions[id], revision)
+}
+
+// listRevisions lists the revisions of a post, newest first
+func (s *Server) listRevisions(w http.ResponseWriter, r *http.Request, id int) {
+	query := r.URL.Query()
+	perPage := len(s.revisions[id])
+	if n, err := strconv.Atoi(query.Get("per_page")); err == nil && n < perPage {
+		perPage = n
+	}
+
+	views := []Record{}
+	for i := len(s.revisions[id]) - 1; i >= 0 && len(views) < perPage; i-- {
+		views = append(views, project(s.view(s.revisions[id][i], query.Get("context")), query.Get("_fields")))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request, restBase string, record Record) {
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	if !force {
+		if record["status"] == "trash" {
+			writeError(w, http.StatusGone, "rest_already_trashed", "The post has already been deleted.", nil)
+			return
+		}
+		record["status"] = "trash"
+		writeJSON(w, http.StatusOK, s.view(record, "edit"))
+		return
+	}
+
+	delete(s.posts[restBase], intField(record, "id"))
+	if details, ok := record["media_details"].(map[string]interface{}); ok && restBase == "media" {
+		if file, ok := details["file"].(string); ok {
+			delete(s.uploads, file)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"deleted":  true,
+		"previous": s.view(record, "edit")})
+}
+
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] == "" {
+		writeError(w, http.StatusBadRequest, "rest_upload_no_content_disposition",
+			"No Content-Disposition supplied.", nil)
+		return
+	}
+
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil || len(content) == 0 {
+		writeError(w, http.StatusBadRequest, "rest_upload_no_data", "No data supplied.", nil)
+		return
+	}
+
+	filename := path.Base(params["filename"])
+	if s.UploadsByMonth {
+		filename = time.Now().UTC().Format("2006/01/") + filename
+	}
+	name := s.uniqueFilename(filename)
+	s.uploads[name] = content
+
+	mimeType := r.Header.Get("Content-Type")
+	if mimeType == "" {
+		mimeType = http.DetectContentType(content)
+	}
+
+	record := s.newRecord("media")
+	base := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	record["title"] = map[string]interface{}{"raw": base, "rendered": base}
+	record["status"] = "inherit"
+	record["mime_type"] = mimeType
+	record["source_url"] = s.URL + uploadsPrefix + name
+	record["media_details"] = map[string]interface{}{"file": name, "filesize": len(content)}
+
+	// With the file as the request body, other fields are passed as query
+	// parameters
+	fields := Record{}
+	for _, key := range []string{"title", "alt_text", "caption", "description", "post"} {
+		if value := r.URL.Query().Get(key); value != "" {
+			fields[key] = value
+		}
+	}
+	if post, err := strconv.Atoi(r.URL.Query().Get("post")); err == nil {
+		fields["post"] = post
+	}
+	s.merge(record, fields)
+
+	// As with wp_insert_attachment, the slug is derived from the title
+	// unless given
+	slug := r.URL.Query().Get("slug")
+	if slug == "" {
+		title, _ := record["title"].(map[string]interface{})
+		raw, _ := title["raw"].(string)
+		slug = sanitiseTitle(raw)
+	}
+	record["slug"] = s.uniqueSlug("media", slug, intField(record, "id"))
+	writeJSON(w, http.StatusCreated, s.view(record, "edit"))
+}
+
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	content, ok := s.uploads[strings.TrimPrefix(r.URL.Path, uploadsPrefix)]
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(content))
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != "HEAD" {
+		w.Write(content)
+	}
+}
+
+func (s *Server) newRecord(restBase string) Record {
+	if s.posts[restBase] == nil {
+		s.posts[restBase] = make(map[int]Record)
+	}
+	record := Record{
+		"id":           s.nextID,
+		"status":       "publish",
+		"parent":       0,
+		"menu_order":   0,
+		"date_gmt":     now(),
+		"modified_gmt": now()}
+	s.posts[restBase][s.nextID] = record
+	s.nextID++
+	return record
+}
+
+// merge applies request fields to a record, expanding the plain string form
+// of rendered fields into the raw/rendered object WordPress returns
+func (s *Server) merge(record, fields Record) {
+	for key, value := range fields {
+		switch key {
+		case "id":
+			continue
+		case "title", "content", "excerpt", "caption", "description":
+			switch v := value.(type) {
+			case string:
+				value = map[string]interface{}{"raw": v, "rendered": v}
+			case map[string]interface{}:
+				raw, _ := v["raw"].(string)
+				value = map[string]interface{}{"raw": raw, "rendered": raw}
+			}
+		case "meta":
+			// Meta is updated key by key rather than replaced
+			existing, _ := record["meta"].(map[string]interface{})
+			if existing == nil {
+				existing = map[string]interface{}{}
+			}
+			if updates, ok := value.(map[string]interface{}); ok {
+				for k, v := range updates {
+					existing[k] = v
+				}
+			}
+			value = existing
+		case "parent", "menu_order", "author", "featured_media", "post":
+			if f, ok := value.(float64); ok {
+				value = int(f)
+			}
+		}
+		record[key] = value
+	}
+}
+
+// sanitiseTitle approximates sanitize_title, by which WordPress derives a slug
+// from a title
+func sanitiseTitle(title string) string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	return strings.Join(words, "-")
+}
+
+// uniqueSlug mimics wp_unique_post_slug, suffixing "-2", "-3" etc. to a slug
+// that is already taken by another record of the same type
+func (s *Server) uniqueSlug(restBase, slug string, id int) string {
+	if slug == "" {
+		slug = strconv.Itoa(id)
+	}
+	taken := func(candidate string) bool {
+		for otherID, other := range s.posts[restBase] {
+			if otherID != id && other["slug"] == candidate {
+				return true
+			}
+		}
+		return false
+	}
+
+	candidate := slug
+	for suffix := 2; taken(candidate); suffix++ {
+		candidate = fmt.Sprintf("%s-%d", slug, suffix)
+	}
+	return candidate
+}
+
+// uniqueFilename mimics wp_unique_filename, suffixing "-1", "-2" etc. to the
+// base of a filename that already exists in the uploads directory
+func (s *Server) uniqueFilename(name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	candidate := name
+	for suffix := 1; ; suffix++ {
+		if _, ok := s.uploads[candidate]; !ok {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d%s", base, suffix, ext)
+	}
+}
+
+func (s *Server) sorted(restBase string) []Record {
+	var ids []int
+	for id := range s.posts[restBase] {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var records []Record
+	for _, id := range ids {
+		records = append(records, s.posts[restBase][id])
+	}
+	return records
+}
+
+// view renders a record as returned for the given context; raw fields are
+// only included in the edit context
+func (s *Server) view(record Record, context string) Record {
+	view := copyRecord(record)
+	if context == "edit" {
+		return view
+	}
+	for key, value := range view {
+		if object, ok := value.(map[string]interface{}); ok {
+			if _, ok := object["raw"]; ok {
+				view[key] = map[string]interface{}{"rendered": object["rendered"]}
+			}
+		}
+	}
+	return view
+}
+
+// project implements the _fields parameter, including the nested form (e.g.
+// content.raw) supported since WordPress 5.3
+func project(view Record, fields string) Record {
+	if fields == "" {
+		return view
+	}
+
+	projected := Record{}
+	for _, field := range strings.Split(fields, ",") {
+		parts := strings.SplitN(strings.TrimSpace(field), ".", 2)
+		value, ok := view[parts[0]]
+		if !ok {
+			continue
+		}
+		if len(parts) == 1 {
+			projected[parts[0]] = value
+			continue
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		nested, ok := object[parts[1]]
+		if !ok {
+			continue
+		}
+		target, _ := projected[parts[0]].(map[string]interface{})
+		if target == nil {
+			target = map[string]interface{}{}
+			projected[parts[0]] = target
+		}
+		target[parts[1]] = nested
+	}
+	return projected
+}
+
+// matchesQuery implements the collection filters used by wordepress: slug,
+// status, search and the rest-filter plugin's filter[meta_query]
+func matchesQuery(record Record, query url.Values) bool {
+	if slugs := query.Get("slug"); slugs != "" {
+		found := false
+		for _, slug := range strings.Split(slugs, ",") {
+			if record["slug"] == slug {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	switch status := query.Get("status"); status {
+	case "any":
+		if record["status"] == "trash" {
+			return false
+		}
+	case "":
+		if record["status"] != "publish" && record["status"] != "inherit" {
+			return false
+		}
+	default:
+		if record["status"] != status {
+			return false
+		}
+	}
+
+	if search := strings.ToLower(query.Get("search")); search != "" {
+		title, _ := record["title"].(map[string]interface{})
+		raw, _ := title["raw"].(string)
+		if !strings.Contains(strings.ToLower(raw), search) &&
+			!strings.Contains(stringField(record, "slug"), search) {
+			return false
+		}
+	}
+
+	for i := 0; ; i++ {
+		key := query.Get(fmt.Sprintf("filter[meta_query][%d][key]", i))
+		if key == "" {
+			break
+		}
+		value := query.Get(fmt.Sprintf("filter[meta_query][%d][value]", i))
+		stored, ok := record[key]
+		if meta, isMap := record["meta"].(map[string]interface{}); !ok && isMap {
+			stored, ok = meta[key]
+		}
+		if !ok || fmt.Sprint(stored) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func copyRecord(record Record) Record {
+	// Round trip through JSON for a deep copy with consistent value types
+	bytes, _ := json.Marshal(record)
+	var copied Record
+	json.Unmarshal(bytes, &copied)
+	return copied
+}
+
+func stringField(record Record, key string) string {
+	value, _ := record[key].(string)
+	return value
+}
+
+func intField(record Record, key string) int {
+	switch value := record[key].(type) {
+	case int:
+		return value
+	case float64:
+		return int(value)
+	}
+	return 0
+}
+
+func now() string {
+	return time.Now().UTC().Format("2006-01-02T15:04:05")
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string, params map[string]interface{}) {
+	data := map[string]interface{}{"status": status}
+	if params != nil {
+		data["params"] = params
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"code":    code,
+		"message": message,
+		"data":    data})
+}
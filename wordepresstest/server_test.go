@@ -0,0 +1,204 @@
+package wordepresstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// call makes a REST request of the server, decoding the JSON response into
+// value if given
+func call(t *testing.T, server *Server, method, path, body string, value interface{}) *http.Response {
+	request, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	bytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != nil {
+		if err := json.Unmarshal(bytes, value); err != nil {
+			t.Fatalf("%s %s: %v: %s", method, path, err, bytes)
+		}
+	}
+	return response
+}
+
+func TestListPaging(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	for i := 1; i <= 5; i++ {
+		server.AddPost("documentation", Record{"slug": fmt.Sprintf("page-%d", i)})
+	}
+
+	var records []Record
+	response := call(t, server, "GET", "/wp-json/wp/v2/documentation?per_page=2&page=3", "", &records)
+	if total := response.Header.Get("X-WP-Total"); total != "5" {
+		t.Errorf("X-WP-Total %q, want 5", total)
+	}
+	if pages := response.Header.Get("X-WP-TotalPages"); pages != "3" {
+		t.Errorf("X-WP-TotalPages %q, want 3", pages)
+	}
+	if len(records) != 1 || records[0]["slug"] != "page-5" {
+		t.Errorf("last page %v, want page-5 alone", records)
+	}
+
+	var apiError struct{ Code string }
+	response = call(t, server, "GET", "/wp-json/wp/v2/documentation?per_page=2&page=4", "", &apiError)
+	if response.StatusCode != http.StatusBadRequest || apiError.Code != "rest_post_invalid_page_number" {
+		t.Errorf("past the last page: %d %s", response.StatusCode, apiError.Code)
+	}
+
+	server.EmptyPastLastPage = true
+	response = call(t, server, "GET", "/wp-json/wp/v2/documentation?per_page=2&page=4", "", &records)
+	if response.StatusCode != http.StatusOK || len(records) != 0 {
+		t.Errorf("past the last page with EmptyPastLastPage: %d %v", response.StatusCode, records)
+	}
+
+	response = call(t, server, "GET", "/wp-json/wp/v2/documentation?per_page=101", "", &apiError)
+	if response.StatusCode != http.StatusBadRequest || apiError.Code != "rest_invalid_param" {
+		t.Errorf("per_page over 100: %d %s", response.StatusCode, apiError.Code)
+	}
+}
+
+func TestCreateUniqueSlug(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	var ids []int
+	for _, want := range []string{"install", "install-2", "install-3"} {
+		var record Record
+		response := call(t, server, "POST", "/wp-json/wp/v2/documentation", `{"slug": "install", "title": "Install"}`, &record)
+		if response.StatusCode != http.StatusCreated {
+			t.Fatalf("create: %d", response.StatusCode)
+		}
+		if record["slug"] != want {
+			t.Errorf("slug %q, want %q", record["slug"], want)
+		}
+		ids = append(ids, intField(record, "id"))
+	}
+	path := fmt.Sprintf("/wp-json/wp/v2/documentation/%d", ids[1])
+
+	// Updating a record to its own slug leaves it alone, but taking another's
+	// is suffixed
+	var record Record
+	call(t, server, "PUT", path, `{"slug": "install-2"}`, &record)
+	if record["slug"] != "install-2" {
+		t.Errorf("slug %q after update to its own, want install-2", record["slug"])
+	}
+	call(t, server, "PUT", path, `{"slug": "install-3"}`, &record)
+	if record["slug"] != "install-3-2" {
+		t.Errorf("slug %q after update to another's, want install-3-2", record["slug"])
+	}
+
+	// Slugs are only unique within a post type
+	call(t, server, "POST", "/wp-json/wp/v2/pages", `{"slug": "install"}`, &record)
+	if record["slug"] != "install" {
+		t.Errorf("page slug %q, want install", record["slug"])
+	}
+}
+
+func TestDelete(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	id := server.AddPost("documentation", Record{"slug": "install"})
+	path := fmt.Sprintf("/wp-json/wp/v2/documentation/%d", id)
+
+	var record Record
+	call(t, server, "DELETE", path, "", &record)
+	if record["status"] != "trash" {
+		t.Errorf("status %q after delete, want trash", record["status"])
+	}
+	var records []Record
+	call(t, server, "GET", "/wp-json/wp/v2/documentation?status=any", "", &records)
+	if len(records) != 0 {
+		t.Errorf("status=any listed %d trashed records", len(records))
+	}
+	var apiError struct{ Code string }
+	response := call(t, server, "DELETE", path, "", &apiError)
+	if response.StatusCode != http.StatusGone || apiError.Code != "rest_already_trashed" {
+		t.Errorf("second delete: %d %s", response.StatusCode, apiError.Code)
+	}
+
+	var deleted struct {
+		Deleted  bool
+		Previous Record
+	}
+	call(t, server, "DELETE", path+"?force=true", "", &deleted)
+	if !deleted.Deleted || deleted.Previous["slug"] != "install" {
+		t.Errorf("force delete returned %+v", deleted)
+	}
+	if posts := server.Posts("documentation"); len(posts) != 0 {
+		t.Errorf("%d records remain after force delete", len(posts))
+	}
+	response = call(t, server, "GET", path, "", &apiError)
+	if response.StatusCode != http.StatusNotFound || apiError.Code != "rest_post_invalid_id" {
+		t.Errorf("get after force delete: %d %s", response.StatusCode, apiError.Code)
+	}
+}
+
+func TestFields(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	id := server.AddPost("documentation", Record{
+		"slug":    "install",
+		"title":   "Install",
+		"content": "Hello",
+		"meta":    map[string]interface{}{"wordepress_product": "scope"}})
+
+	var record Record
+	call(t, server, "GET", fmt.Sprintf("/wp-json/wp/v2/documentation/%d?_fields=id,slug", id), "", &record)
+	if len(record) != 2 || record["slug"] != "install" {
+		t.Errorf("_fields=id,slug returned %v", record)
+	}
+
+	var records []Record
+	call(t, server, "GET", "/wp-json/wp/v2/documentation?context=edit&_fields=content.raw,meta,missing", "", &records)
+	if len(records) != 1 {
+		t.Fatalf("listed %d records", len(records))
+	}
+	want := `{"content":{"raw":"Hello"},"meta":{"wordepress_product":"scope"}}`
+	if got, _ := json.Marshal(records[0]); string(got) != want {
+		t.Errorf("nested _fields returned %s, want %s", got, want)
+	}
+
+	// Raw fields are only in the edit context
+	call(t, server, "GET", "/wp-json/wp/v2/documentation?_fields=title", "", &records)
+	if title := records[0]["title"].(map[string]interface{}); title["raw"] != nil || title["rendered"] != "Install" {
+		t.Errorf("view context title %v", title)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.User, server.Password = "admin", "secret"
+
+	var apiError struct{ Code string }
+	response := call(t, server, "GET", "/wp-json/wp/v2/documentation", "", &apiError)
+	if response.StatusCode != http.StatusUnauthorized || apiError.Code != "rest_not_logged_in" {
+		t.Errorf("without credentials: %d %s", response.StatusCode, apiError.Code)
+	}
+
+	request, _ := http.NewRequest("GET", server.URL+"/wp-json/wp/v2/documentation", nil)
+	request.SetBasicAuth("admin", "secret")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("with credentials: %d", response.StatusCode)
+	}
+}